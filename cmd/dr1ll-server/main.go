@@ -1,13 +1,26 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/M1z23R/dr1ll/internal/config"
+	"github.com/M1z23R/dr1ll/internal/logging"
 	"github.com/M1z23R/dr1ll/internal/server"
+	"github.com/M1z23R/dr1ll/internal/tracing"
 )
 
 func main() {
@@ -23,6 +36,18 @@ func main() {
 		startCommand()
 	case "config":
 		configCommand()
+	case "usage":
+		usageCommand()
+	case "tunnels":
+		tunnelsCommand()
+	case "users":
+		usersCommand()
+	case "tokens":
+		tokensCommand()
+	case "names":
+		namesCommand()
+	case "domains":
+		domainsCommand()
 	case "help", "-h", "--help":
 		showUsage()
 	default:
@@ -38,97 +63,971 @@ func showUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("  dr1ll-server start [options]    Start the tunnel server")
 	fmt.Println("  dr1ll-server config <command>   Manage configuration")
+	fmt.Println("  dr1ll-server usage              Show per-token usage and quotas")
+	fmt.Println("  dr1ll-server tunnels <command>  Manage live tunnels via the admin API")
+	fmt.Println("  dr1ll-server users <command>    Manage users in the store")
+	fmt.Println("  dr1ll-server tokens <command>   Manage tokens in the store")
+	fmt.Println("  dr1ll-server names <command>    Manage reserved subdomains")
+	fmt.Println("  dr1ll-server domains <command>  Manage custom domains")
 	fmt.Println("  dr1ll-server help              Show this help message")
 	fmt.Println("")
 	fmt.Println("Start options:")
 	fmt.Println("  -port <port>            Server port")
 	fmt.Println("  -domain <domain>        Server domain")
 	fmt.Println("  -token <token>          Auth token")
+	fmt.Println("  -admin-addr <addr>      Admin listener for /metrics and the API (e.g. 127.0.0.1:9091)")
+	fmt.Println("  -admin-token <token>    Bearer token enabling the admin API")
+	fmt.Println("  -access-log <path>      Write an access log line per tunneled request")
+	fmt.Println("  -access-log-format <f>  Access log format: json or combined (default: json)")
+	fmt.Println("  -trusted-proxies <list> Comma-separated CIDRs whose X-Forwarded-* headers are honored")
+	fmt.Println("  -allow-ips <list>       Only serve clients in these comma-separated CIDRs")
+	fmt.Println("  -deny-ips <list>        Block clients in these comma-separated CIDRs")
+	fmt.Println("  -drain-timeout <d>      How long shutdown waits for in-flight requests (default: 30s)")
+	fmt.Println("  -registry <url>         Tunnel registry: memory (default) or redis://host:6379/0 for a cluster")
+	fmt.Println("  -node-addr <host:port>  Address other nodes reach this node at (required with Redis);")
+	fmt.Println("                          with -tls-cert they connect over HTTPS")
+	fmt.Println("  -cluster-secret <s>     Secret for requests forwarded between nodes (required with Redis)")
+	fmt.Println("  -db <path>              SQLite store path (default: ~/.config/dr1ll/dr1ll.db)")
+	fmt.Println("  -tls-cert <path>        Serve HTTPS with this certificate (PEM)")
+	fmt.Println("  -tls-key <path>         Private key for -tls-cert (PEM)")
+	fmt.Println("  -oidc-issuer <url>      OIDC issuer for tunnels that require sign-in")
+	fmt.Println("  -oidc-client-id <id>    OIDC client ID")
+	fmt.Println("  -oidc-client-secret <s> OIDC client secret")
+	fmt.Println("                          Register https://<domain>/.dr1ll/oauth2/callback as its redirect URI")
+	fmt.Println("  -log-level <level>      Log level: debug, info, warn, error (default: info)")
+	fmt.Println("  -log-format <format>    Log format: text or json (default: text)")
+	fmt.Println("  -quiet                  Suppress banners, only emit logs")
+	fmt.Println("  -otlp-endpoint <url>    Export traces to this OTLP/HTTP collector (e.g. http://localhost:4318)")
 	fmt.Println("")
 	fmt.Println("Configuration priority (highest to lowest):")
 	fmt.Println("  1. Command line flags")
 	fmt.Println("  2. Environment variables")
-	fmt.Println("  3. Config file (~/.config/dr1ll/config.json)")
-	fmt.Println("  4. Built-in defaults")
+	fmt.Println("  3. Project config file (./dr1ll-server.json)")
+	fmt.Println("  4. User config file (~/.config/dr1ll/server.json)")
+	fmt.Println("  5. Built-in defaults")
+	fmt.Println("  'dr1ll-server config show --resolved' prints each setting and its source.")
+	fmt.Println("")
+	fmt.Println("While running, the server reloads its config file when it changes or on")
+	fmt.Println("SIGHUP. Tokens, limits, IP rules, trusted proxies, the drain timeout and")
+	fmt.Println("the TLS certificate apply without dropping tunnels; other settings need a")
+	fmt.Println("restart. Tunnels whose token is removed or changed are disconnected.")
 	fmt.Println("")
 	fmt.Println("Environment variables:")
-	fmt.Println("  TUNNEL_PORT             Server port")
-	fmt.Println("  TUNNEL_DOMAIN           Server domain")
-	fmt.Println("  TUNNEL_TOKEN            Authentication token")
+	fmt.Println("  DR1LL_SERVER_PORT               Server port")
+	fmt.Println("  DR1LL_SERVER_DOMAIN             Server domain")
+	fmt.Println("  DR1LL_SERVER_TOKEN              Authentication token")
+	fmt.Println("  DR1LL_SERVER_ADMIN_ADDR         Admin listener address")
+	fmt.Println("  DR1LL_SERVER_ADMIN_TOKEN        Admin API token")
+	fmt.Println("  DR1LL_SERVER_ACCESS_LOG_PATH    Access log path")
+	fmt.Println("  DR1LL_SERVER_ACCESS_LOG_FORMAT  Access log format")
+	fmt.Println("  DR1LL_SERVER_TRUSTED_PROXIES    Comma-separated trusted proxy CIDRs")
+	fmt.Println("  DR1LL_SERVER_ALLOW_IPS          Comma-separated CIDRs allowed server-wide")
+	fmt.Println("  DR1LL_SERVER_DENY_IPS           Comma-separated CIDRs blocked server-wide")
+	fmt.Println("  DR1LL_SERVER_DRAIN_TIMEOUT      Shutdown drain timeout, e.g. 30s")
+	fmt.Println("  DR1LL_SERVER_REGISTRY           Tunnel registry URL")
+	fmt.Println("  DR1LL_SERVER_NODE_ADDR          Address other nodes reach this node at")
+	fmt.Println("  DR1LL_SERVER_CLUSTER_SECRET     Secret for requests forwarded between nodes")
+	fmt.Println("  DR1LL_SERVER_DB                 SQLite store path")
+	fmt.Println("  DR1LL_SERVER_TLS_CERT           TLS certificate file")
+	fmt.Println("  DR1LL_SERVER_TLS_KEY            TLS private key file")
+	fmt.Println("  DR1LL_SERVER_OIDC_ISSUER_URL    OIDC issuer URL")
+	fmt.Println("  DR1LL_SERVER_OIDC_CLIENT_ID     OIDC client ID")
+	fmt.Println("  DR1LL_SERVER_OIDC_CLIENT_SECRET OIDC client secret")
+	fmt.Println("  DR1LL_SERVER_OIDC_COOKIE_SECRET Key for signing OIDC session cookies")
+	fmt.Println("  DR1LL_LOG_LEVEL                 Log level")
+	fmt.Println("  DR1LL_LOG_FORMAT                Log format")
+	fmt.Println("  DR1LL_OTLP_ENDPOINT             OTLP/HTTP collector URL for traces")
+	fmt.Println("  The TUNNEL_* names of older versions (e.g. TUNNEL_TOKEN) and")
+	fmt.Println("  OTEL_EXPORTER_OTLP_ENDPOINT are still honored.")
+	fmt.Println("")
+	fmt.Println("Tunnels commands:")
+	fmt.Println("  dr1ll-server tunnels list [-admin-url <url>] [-admin-token <token>]")
+	fmt.Println("  dr1ll-server tunnels kill <subdomain> [-admin-url <url>] [-admin-token <token>]")
+	fmt.Println("")
+	fmt.Println("Store commands:")
+	fmt.Println("  dr1ll-server users add <name> [-email <email>]")
+	fmt.Println("  dr1ll-server users list | remove <name>")
+	fmt.Println("  dr1ll-server tokens create <name> [-user <name>] [-bytes-per-day <n>]")
+	fmt.Println("                             [-requests-per-month <n>] [-max-tunnels <n>]")
+	fmt.Println("  dr1ll-server tokens list | revoke <name>")
+	fmt.Println("  dr1ll-server names reserve <subdomain> <token-name>")
+	fmt.Println("  dr1ll-server names list | release <subdomain>")
+	fmt.Println("  dr1ll-server domains add <host> <subdomain> <token-name>")
+	fmt.Println("  dr1ll-server domains list | remove <host>")
 	fmt.Println("")
 	fmt.Println("Config commands:")
 	fmt.Println("  dr1ll-server config set-domain <domain>    Set server domain")
 	fmt.Println("  dr1ll-server config set-port <port>        Set server port")
 	fmt.Println("  dr1ll-server config set-token <token>      Set authentication token")
-	fmt.Println("  dr1ll-server config show                   Show current configuration")
+	fmt.Println("  dr1ll-server config show [--resolved]      Show configuration, or every setting with its source")
+	fmt.Println("  dr1ll-server config encrypt                Encrypt tokens and secrets in server.json")
+	fmt.Println("  dr1ll-server config decrypt                Store them in plain text again")
+	fmt.Println("")
+	fmt.Println("server.json is readable only by you. Encrypted secrets are decrypted with the")
+	fmt.Println("passphrase in DR1LL_PASSPHRASE or the key file named by DR1LL_KEY_FILE.")
 	fmt.Println("")
 	fmt.Println("Config file format:")
 	fmt.Println("  {")
 	fmt.Println("    \"server_port\": \"9090\",")
 	fmt.Println("    \"server_domain\": \"yourdomain.com\",")
-	fmt.Println("    \"server_token\": \"your-secret-token\"")
+	fmt.Println("    \"server_token\": \"your-secret-token\",")
+	fmt.Println("    \"server_admin_addr\": \"127.0.0.1:9091\",")
+	fmt.Println("    \"server_admin_token\": \"your-admin-token\",")
+	fmt.Println("    \"server_drain_timeout\": \"30s\",")
+	fmt.Println("    \"server_tls_cert\": \"/etc/dr1ll/cert.pem\",")
+	fmt.Println("    \"server_tls_key\": \"/etc/dr1ll/key.pem\",")
+	fmt.Println("    \"server_access_log\": {\"path\": \"/var/log/dr1ll/access.log\", \"format\": \"combined\",")
+	fmt.Println("                          \"max_size_mb\": 100, \"max_backups\": 5},")
+	fmt.Println("    \"server_trusted_proxies\": [\"10.0.0.0/8\"],")
+	fmt.Println("    \"server_deny_ips\": [\"203.0.113.0/24\"],")
+	fmt.Println("    \"server_limits\": {")
+	fmt.Println("      \"tunnel\": {\"requests_per_second\": 50, \"max_in_flight\": 20},")
+	fmt.Println("      \"ip\": {\"requests_per_second\": 5, \"burst\": 20}")
+	fmt.Println("    },")
+	fmt.Println("    \"server_tokens\": [")
+	fmt.Println("      {\"name\": \"contractor\", \"token\": \"...\", \"bytes_per_day\": 1073741824,")
+	fmt.Println("       \"requests_per_month\": 100000, \"max_tunnels\": 2}")
+	fmt.Println("    ],")
+	fmt.Println("    \"server_oidc\": {")
+	fmt.Println("      \"issuer_url\": \"https://accounts.example.com\",")
+	fmt.Println("      \"client_id\": \"dr1ll\",")
+	fmt.Println("      \"client_secret\": \"...\",")
+	fmt.Println("      \"allowed_domains\": [\"example.com\"]")
+	fmt.Println("    }")
 	fmt.Println("  }")
 }
 
 func startCommand() {
 	startArgs := os.Args[2:]
-	
+
 	fs := flag.NewFlagSet("start", flag.ExitOnError)
-	
-	cfg, err := config.Load()
-	if err != nil {
-		log.Printf("Failed to load config: %v, using defaults", err)
-		cfg = &config.Config{
-			ServerPort:   "9090",
-			ServerDomain: "mydomain.com",
-			ServerToken:  "some-hard-coded-token",
-		}
-	}
-	
-	defaultPort := getEnvWithConfigFallback("TUNNEL_PORT", cfg.ServerPort, "9090")
-	defaultDomain := getEnvWithConfigFallback("TUNNEL_DOMAIN", cfg.ServerDomain, "mydomain.com")
-	defaultToken := getEnvWithConfigFallback("TUNNEL_TOKEN", cfg.ServerToken, "some-hard-coded-token")
-	
-	port := fs.String("port", defaultPort, "Server port")
-	domain := fs.String("domain", defaultDomain, "Server domain")
-	token := fs.String("token", defaultToken, "Authentication token")
-	
+	options := config.ServerOptions()
+	config.RegisterFlags(fs, options)
+	fs.BoolVar(&quiet, "quiet", false, "Suppress banners, only emit logs")
+
 	fs.Parse(startArgs)
 
-	if *token == "some-hard-coded-token" {
-		log.Println("⚠️  Using default token. Set TUNNEL_TOKEN env var or use -token flag for production")
+	cfg := loadConfig()
+	opts := serverResolver(fs, options)
+
+	port := opts.Get("server_port")
+	domain := opts.Get("server_domain")
+	token := opts.Get("server_token")
+	adminAddr := opts.Get("server_admin_addr")
+	adminToken := opts.Get("server_admin_token")
+	accessLogPath := opts.Get("server_access_log.path")
+	accessLogFormat := opts.Get("server_access_log.format")
+	trustedProxies := opts.Get("server_trusted_proxies")
+	allowIPs := opts.Get("server_allow_ips")
+	denyIPs := opts.Get("server_deny_ips")
+	registryURL := opts.Get("server_registry")
+	nodeAddr := opts.Get("server_node_addr")
+	clusterSecret := opts.Get("server_cluster_secret")
+	dbPath := opts.Get("server_db")
+	oidcIssuer := opts.Get("server_oidc.issuer_url")
+	oidcClientID := opts.Get("server_oidc.client_id")
+	oidcClientSecret := opts.Get("server_oidc.client_secret")
+	tlsCert := opts.Get("server_tls_cert")
+	tlsKey := opts.Get("server_tls_key")
+	logLevel := opts.Get("log_level")
+	logFormat := opts.Get("log_format")
+	otlpEndpoint := opts.Get("otlp_endpoint")
+
+	drainTimeout, err := time.ParseDuration(opts.Get("server_drain_timeout"))
+	if err != nil {
+		fatal("invalid drain timeout", "error", err)
+	}
+	accessLogSettings := cfg.ServerAccessLog
+	if accessLogSettings == nil {
+		accessLogSettings = &config.AccessLogSettings{}
+	}
+	oidcSettings := cfg.ServerOIDC
+	if oidcSettings == nil {
+		oidcSettings = &config.OIDCSettings{}
+	}
+
+	if err := logging.Setup(logLevel, logFormat); err != nil {
+		fatal("invalid logging options", "error", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "dr1ll-server", otlpEndpoint)
+	if err != nil {
+		fatal("failed to set up tracing", "error", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	if token == "" {
+		fatal("no auth token configured, use -token, DR1LL_SERVER_TOKEN or 'dr1ll-server config set-token <token>'")
+	}
+
+	if domain == "mydomain.com" {
+		slog.Warn("using default domain, set TUNNEL_DOMAIN or use -domain")
+	}
+
+	banner("🚀 Starting tunnel server\n")
+	banner("🌐 Domain: %s\n", domain)
+	banner("🔌 Port: %s\n", port)
+	banner("🔑 Token: %s***\n", token[:min(len(token), 8)])
+
+	srv := server.NewServer(token, domain, port)
+	if adminAddr != "" {
+		srv.SetAdminAddr(adminAddr)
+		srv.SetAdminToken(adminToken)
+		banner("📊 Admin: %s\n", adminAddr)
+		if adminToken == "" {
+			slog.Warn("no admin token set, the admin API is disabled")
+		}
+	}
+	if accessLogPath != "" {
+		err := srv.SetAccessLog(server.AccessLogConfig{
+			Path:       accessLogPath,
+			Format:     accessLogFormat,
+			MaxSizeMB:  accessLogSettings.MaxSizeMB,
+			MaxBackups: accessLogSettings.MaxBackups,
+		})
+		if err != nil {
+			fatal("failed to open access log", "error", err)
+		}
+		banner("📜 Access log: %s\n", accessLogPath)
+	}
+	if err := srv.ApplySettings(settingsFrom(cfg, opts)); err != nil {
+		fatal("invalid configuration", "error", err)
+	}
+	if trustedProxies != "" {
+		banner("🛡️  Trusted proxies: %s\n", trustedProxies)
+	}
+	if allowIPs != "" || denyIPs != "" {
+		banner("🛡️  Server-wide IP rules applied\n")
+	}
+	if cfg.ServerLimits != nil {
+		banner("🚦 Rate limits applied\n")
+	}
+	if len(cfg.ServerTokens) > 0 {
+		banner("🔑 Additional tokens: %d\n", len(cfg.ServerTokens))
+	}
+	if tlsCert != "" {
+		if tlsKey == "" {
+			fatal("-tls-cert requires -tls-key")
+		}
+		if err := srv.SetTLSCertificate(tlsCert, tlsKey); err != nil {
+			fatal("invalid TLS certificate", "error", err)
+		}
+		banner("🔐 TLS: %s\n", tlsCert)
+	}
+	switch {
+	case registryURL == "" || registryURL == "memory":
+	case strings.HasPrefix(registryURL, "redis://"), strings.HasPrefix(registryURL, "rediss://"):
+		if nodeAddr == "" {
+			fatal("a shared registry requires -node-addr")
+		}
+		// Forwarded requests skip tunnel authentication, so the secret must
+		// not be something tunnel clients also know.
+		if clusterSecret == "" {
+			fatal("a shared registry requires -cluster-secret")
+		}
+		if clusterSecret == token || slices.ContainsFunc(cfg.ServerTokens, func(t config.TokenSettings) bool { return t.Token == clusterSecret }) {
+			fatal("-cluster-secret must differ from the auth tokens")
+		}
+		registry, err := server.NewRedisRegistry(registryURL)
+		if err != nil {
+			fatal("failed to connect to registry", "error", err)
+		}
+		srv.SetRegistry(registry)
+		srv.SetNodeAddr(nodeAddr)
+		srv.SetClusterSecret(clusterSecret)
+		banner("🗂️  Redis registry, node %s\n", nodeAddr)
+	default:
+		fatal("unsupported registry, expected memory or a redis:// URL", "registry", registryURL)
+	}
+	st := openStore(dbPath)
+	defer st.Close()
+	if err := srv.SetStore(st); err != nil {
+		fatal("failed to load usage", "error", err)
+	}
+	banner("🗄️  Store: %s\n", dbPath)
+	if oidcIssuer != "" {
+		// Every node must verify the sessions the others sign.
+		if registryURL != "" && registryURL != "memory" && opts.Get("server_oidc.cookie_secret") == "" {
+			fatal("OIDC with a shared registry requires a cookie secret, set DR1LL_SERVER_OIDC_COOKIE_SECRET")
+		}
+		var sessionTTL time.Duration
+		if oidcSettings.SessionTTL != "" {
+			if sessionTTL, err = time.ParseDuration(oidcSettings.SessionTTL); err != nil {
+				fatal("invalid OIDC session TTL", "error", err)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := srv.EnableOIDC(ctx, server.OIDCConfig{
+			IssuerURL:      oidcIssuer,
+			ClientID:       oidcClientID,
+			ClientSecret:   oidcClientSecret,
+			Scopes:         oidcSettings.Scopes,
+			CookieSecret:   opts.Get("server_oidc.cookie_secret"),
+			SessionTTL:     sessionTTL,
+			AllowedDomains: oidcSettings.AllowedDomains,
+			AllowedGroups:  oidcSettings.AllowedGroups,
+			GroupsClaim:    oidcSettings.GroupsClaim,
+		})
+		cancel()
+		if err != nil {
+			fatal("failed to enable OIDC login", "error", err)
+		}
+		banner("🔐 OIDC login: %s\n", oidcIssuer)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Start() }()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	reloader := &configReloader{srv: srv, fs: fs, options: options, opts: opts, cfg: cfg, tls: tlsCert != "", drainTimeout: drainTimeout}
+	reloader.stamp = reloader.fileStamp()
+	poll := time.NewTicker(configPollInterval)
+	defer poll.Stop()
+
+wait:
+	for {
+		select {
+		case err := <-errc:
+			fatal("server failed to start", "error", err)
+		case <-hup:
+			slog.Info("SIGHUP received, reloading configuration")
+			reloader.reload()
+		case <-poll.C:
+			if reloader.fileStamp() != reloader.stamp {
+				slog.Info("configuration files changed, reloading")
+				reloader.reload()
+			}
+		case sig := <-stop:
+			slog.Info("signal received, shutting down", "signal", sig.String(), "drain_timeout", reloader.drainTimeout)
+			banner("🛑 Shutting down, draining for up to %s...\n", reloader.drainTimeout)
+			break wait
+		}
+	}
+	drainTimeout = reloader.drainTimeout
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("shutdown did not complete cleanly", "error", err)
+	}
+}
+
+// configPollInterval is how often the server checks its config file and TLS
+// certificate for changes.
+const configPollInterval = 2 * time.Second
+
+// reloadableFields are the config fields a running server applies; changes
+// to the others only take effect after a restart.
+var reloadableFields = map[string]bool{
+	"server_token":           true,
+	"server_tokens":          true,
+	"server_limits":          true,
+	"server_trusted_proxies": true,
+	"server_allow_ips":       true,
+	"server_deny_ips":        true,
+	"server_tls_cert":        true,
+	"server_tls_key":         true,
+	"server_drain_timeout":   true,
+}
+
+// configReloader applies changes to the config files, and to the TLS
+// certificate, to a running server.
+type configReloader struct {
+	srv          *server.Server
+	fs           *flag.FlagSet
+	options      []config.Option
+	opts         *config.Resolver
+	cfg          *config.ServerConfig
+	tls          bool // whether the server was started with TLS
+	drainTimeout time.Duration
+	stamp        string
+}
+
+// reload re-reads the config files and applies what can change at runtime.
+// An unreadable or invalid file leaves the running configuration in place.
+func (r *configReloader) reload() {
+	defer func() { r.stamp = r.fileStamp() }()
+
+	cfg, err := config.LoadServer()
+	if errors.Is(err, config.ErrNoConfig) {
+		cfg, err = &config.ServerConfig{}, nil
+	}
+	if err != nil {
+		slog.Error("failed to reload configuration, keeping the current one", "error", err)
+		return
+	}
+	opts, err := config.NewServerResolver(r.fs, r.options)
+	if err != nil {
+		slog.Error("failed to reload configuration, keeping the current one", "error", err)
+		return
+	}
+
+	settings := settingsFrom(cfg, opts)
+	drainTimeout, err := time.ParseDuration(opts.Get("server_drain_timeout"))
+	if err != nil {
+		slog.Error("invalid drain timeout, keeping the current configuration", "error", err)
+		return
+	}
+	if err := r.srv.ApplySettings(settings); err != nil {
+		slog.Error("invalid configuration, keeping the current one", "error", err)
+		return
+	}
+	r.drainTimeout = drainTimeout
+
+	changes := config.DiffServer(r.cfg, cfg)
+	for _, change := range changes {
+		if reloadableFields[change.Field] {
+			slog.Info("configuration changed", "field", change.Field, "old", change.Old, "new", change.New)
+		} else {
+			slog.Warn("configuration changed, restart to apply", "field", change.Field, "old", change.Old, "new", change.New)
+		}
+	}
+	r.cfg, r.opts = cfg, opts
+
+	certFile, keyFile := opts.Get("server_tls_cert"), opts.Get("server_tls_key")
+	switch {
+	case certFile != "" && r.tls:
+		if err := r.srv.SetTLSCertificate(certFile, keyFile); err != nil {
+			slog.Error("failed to reload TLS certificate, keeping the current one", "error", err)
+		}
+	case certFile != "" || r.tls:
+		slog.Warn("enabling or disabling TLS requires a restart")
+	}
+
+	slog.Info("configuration reloaded", "changes", len(changes))
+}
+
+// fileStamp summarizes the modification times and sizes of the config files
+// and TLS files, so polling can tell when they change.
+func (r *configReloader) fileStamp() string {
+	configPath, _ := config.GetServerConfigPath()
+	paths := []string{
+		configPath,
+		config.ProjectServerConfigName,
+		r.opts.Get("server_tls_cert"),
+		r.opts.Get("server_tls_key"),
+	}
+
+	var stamp strings.Builder
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&stamp, "%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return stamp.String()
+}
+
+// settingsFrom gathers the runtime-changeable settings from cfg and the
+// resolved options.
+func settingsFrom(cfg *config.ServerConfig, opts *config.Resolver) server.Settings {
+	settings := server.Settings{
+		Token:          opts.Get("server_token"),
+		TrustedProxies: splitList(opts.Get("server_trusted_proxies")),
+		AllowIPs:       splitList(opts.Get("server_allow_ips")),
+		DenyIPs:        splitList(opts.Get("server_deny_ips")),
+	}
+	if cfg.ServerLimits != nil {
+		settings.Limits = &server.Limits{
+			Tunnel: limitFrom(cfg.ServerLimits.Tunnel),
+			Token:  limitFrom(cfg.ServerLimits.Token),
+			IP:     limitFrom(cfg.ServerLimits.IP),
+		}
+	}
+	for _, t := range cfg.ServerTokens {
+		settings.Tokens = append(settings.Tokens, server.TokenConfig{
+			Name:  t.Name,
+			Token: t.Token,
+			Quota: server.Quota{
+				BytesPerDay:      t.BytesPerDay,
+				RequestsPerMonth: t.RequestsPerMonth,
+				MaxTunnels:       t.MaxTunnels,
+			},
+		})
+	}
+	return settings
+}
+
+func limitFrom(l config.LimitSettings) server.Limit {
+	return server.Limit{
+		RequestsPerSecond: l.RequestsPerSecond,
+		Burst:             l.Burst,
+		MaxInFlight:       l.MaxInFlight,
+	}
+}
+
+// serverResolver resolves the server's options, exiting on an unreadable
+// config file.
+func serverResolver(fs *flag.FlagSet, options []config.Option) *config.Resolver {
+	opts, err := config.NewServerResolver(fs, options)
+	if err != nil {
+		fatal("failed to load configuration", "error", err)
+	}
+	return opts
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func usageCommand() {
+	cfg := loadConfig()
+
+	st := openStore(serverResolver(nil, config.ServerOptions()).Get("server_db"))
+	defer st.Close()
+	usage, err := st.LoadUsage()
+	if err != nil {
+		fatal("failed to load usage", "error", err)
+	}
+	stored, err := st.Tokens()
+	if err != nil {
+		fatal("failed to load tokens", "error", err)
+	}
+
+	quotas := map[string]server.Quota{server.DefaultTokenName: {}}
+	names := []string{server.DefaultTokenName}
+	for _, t := range cfg.ServerTokens {
+		quotas[t.Name] = server.Quota{BytesPerDay: t.BytesPerDay, RequestsPerMonth: t.RequestsPerMonth, MaxTunnels: t.MaxTunnels}
+		names = append(names, t.Name)
+	}
+	for _, t := range stored {
+		if _, ok := quotas[t.Name]; !ok {
+			quotas[t.Name] = t.Quota
+			names = append(names, t.Name)
+		}
+	}
+	for name := range usage {
+		if _, ok := quotas[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TOKEN\tBYTES TODAY\tREQUESTS THIS MONTH\tMAX TUNNELS\tTOTAL BYTES\tTOTAL REQUESTS")
+	for _, name := range names {
+		var current server.Usage
+		if u, ok := usage[name]; ok {
+			current = u.Current(time.Now())
+		}
+		quota := quotas[name]
+		fmt.Fprintf(w, "%s\t%s / %s\t%d / %s\t%s\t%s\t%d\n",
+			name,
+			formatBytes(current.BytesToday), limitString(quota.BytesPerDay, formatBytes),
+			current.RequestsThisMonth, limitString(quota.RequestsPerMonth, func(n int64) string { return fmt.Sprint(n) }),
+			limitString(int64(quota.MaxTunnels), func(n int64) string { return fmt.Sprint(n) }),
+			formatBytes(current.BytesTotal), current.RequestsTotal)
+	}
+	w.Flush()
+}
+
+func tunnelsCommand() {
+	if len(os.Args) < 3 {
+		fmt.Println("Tunnels command required. Available commands:")
+		fmt.Println("  list                List connected tunnels")
+		fmt.Println("  kill <subdomain>    Disconnect a tunnel")
+		os.Exit(1)
+	}
+
+	subcommand := os.Args[2]
+	args := os.Args[3:]
+
+	var subdomain string
+	if subcommand == "kill" {
+		if len(args) < 1 || strings.HasPrefix(args[0], "-") {
+			fmt.Println("Usage: dr1ll-server tunnels kill <subdomain>")
+			os.Exit(1)
+		}
+		subdomain, args = args[0], args[1:]
+	}
+
+	options := []config.Option{
+		{Key: "admin_url", Flag: "admin-url", Aliases: []string{"TUNNEL_ADMIN_URL"}, Usage: "Admin API base URL"},
+		{Key: "server_admin_addr"},
+		{Key: "server_admin_token", Flag: "admin-token", Aliases: []string{"TUNNEL_ADMIN_TOKEN"}, Secret: true, Usage: "Admin API token"},
+	}
+	fs := flag.NewFlagSet("tunnels", flag.ExitOnError)
+	config.RegisterFlags(fs, options)
+	fs.Parse(args)
+	opts := serverResolver(fs, options)
+
+	adminURL := opts.Get("admin_url")
+	if adminURL == "" && opts.Get("server_admin_addr") != "" {
+		adminURL = "http://" + opts.Get("server_admin_addr")
+	}
+	adminToken := opts.Get("server_admin_token")
+
+	if adminURL == "" {
+		fatal("no admin URL configured, use -admin-url or set server_admin_addr in the config")
+	}
+	if adminToken == "" {
+		fatal("no admin token configured, use -admin-token or set DR1LL_SERVER_ADMIN_TOKEN")
+	}
+
+	switch subcommand {
+	case "list":
+		var tunnels []server.TunnelInfo
+		if err := adminRequest(http.MethodGet, adminURL+"/api/tunnels", adminToken, &tunnels); err != nil {
+			fatal("failed to list tunnels", "error", err)
+		}
+		if len(tunnels) == 0 {
+			fmt.Println("No tunnels connected")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SUBDOMAIN\tTOKEN\tREMOTE\tUPTIME\tREQUESTS\tIN FLIGHT\tBYTES IN\tBYTES OUT\tERRORS")
+		for _, t := range tunnels {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t%d\n",
+				t.Subdomain, t.Token, t.RemoteAddr, time.Since(t.ConnectedAt).Round(time.Second),
+				t.Stats.Requests, t.Stats.InFlight, formatBytes(t.Stats.BytesIn), formatBytes(t.Stats.BytesOut), t.Stats.Errors)
+		}
+		w.Flush()
+
+	case "kill":
+		if err := adminRequest(http.MethodDelete, adminURL+"/api/tunnels/"+url.PathEscape(subdomain), adminToken, nil); err != nil {
+			fatal("failed to kill tunnel", "error", err)
+		}
+		fmt.Printf("✅ Tunnel %s disconnected\n", subdomain)
+
+	default:
+		fmt.Printf("Unknown tunnels command: %s\n", subcommand)
+		os.Exit(1)
 	}
-	
-	if *domain == "mydomain.com" {
-		log.Println("⚠️  Using default domain. Set TUNNEL_DOMAIN env var or use -domain flag")
+}
+
+// adminRequest calls the admin API and decodes a JSON response into out.
+func adminRequest(method, endpoint, token string, out any) error {
+	req, err := http.NewRequest(method, endpoint, nil)
+	if err != nil {
+		return err
 	}
+	req.Header.Set("Authorization", "Bearer "+token)
 
-	fmt.Printf("🚀 Starting tunnel server\n")
-	fmt.Printf("🌐 Domain: %s\n", *domain)
-	fmt.Printf("🔌 Port: %s\n", *port)
-	fmt.Printf("🔑 Token: %s***\n", (*token)[:min(len(*token), 8)])
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-	srv := server.NewServer(*token, *domain, *port)
-	if err := srv.Start(); err != nil {
-		log.Fatal("Server failed to start:", err)
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s (%d)", apiErr.Error, resp.StatusCode)
+		}
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
 	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// openStore opens the server store, importing the usage.json file older
+// versions kept usage counters in.
+func openStore(path string) *server.Store {
+	st, err := server.OpenStore(path)
+	if err != nil {
+		fatal("failed to open store", "path", path, "error", err)
+	}
+
+	legacyPath, err := config.GetUsagePath()
+	if err != nil {
+		return st
+	}
+	if _, err := os.Stat(legacyPath); err != nil {
+		return st
+	}
+	usage, err := server.NewFileUsageStore(legacyPath).LoadUsage()
+	if err == nil {
+		err = st.SaveUsage(usage)
+	}
+	if err == nil {
+		err = os.Rename(legacyPath, legacyPath+".migrated")
 	}
-	return defaultValue
+	if err != nil {
+		slog.Warn("failed to import legacy usage file", "path", legacyPath, "error", err)
+	} else {
+		slog.Info("imported legacy usage file into the store", "path", legacyPath)
+	}
+	return st
 }
 
-func getEnvWithConfigFallback(envKey, configValue, defaultValue string) string {
-	if value := os.Getenv(envKey); value != "" {
-		return value
+// storeCommand resolves the store location and opens it for a store
+// subcommand.
+func storeCommand() *server.Store {
+	return openStore(serverResolver(nil, config.ServerOptions()).Get("server_db"))
+}
+
+// loadConfig loads the server config file, treating a missing one as empty
+// so flags and environment variables can supply everything.
+func loadConfig() *config.ServerConfig {
+	cfg, err := config.LoadServer()
+	if errors.Is(err, config.ErrNoConfig) {
+		return &config.ServerConfig{}
 	}
-	if configValue != "" {
-		return configValue
+	if err != nil {
+		fatal("failed to load configuration", "error", err)
 	}
-	return defaultValue
+	return cfg
+}
+
+func usersCommand() {
+	if len(os.Args) < 3 {
+		fmt.Println("Users command required. Available commands:")
+		fmt.Println("  add <name> [-email <email>]    Add a user")
+		fmt.Println("  list                           List users")
+		fmt.Println("  remove <name>                  Remove a user and their tokens")
+		os.Exit(1)
+	}
+
+	st := storeCommand()
+	defer st.Close()
+
+	switch os.Args[2] {
+	case "add":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: dr1ll-server users add <name> [-email <email>]")
+			os.Exit(1)
+		}
+		fs := flag.NewFlagSet("users add", flag.ExitOnError)
+		email := fs.String("email", "", "User email")
+		fs.Parse(os.Args[4:])
+		if err := st.AddUser(os.Args[3], *email); err != nil {
+			fatal("failed to add user", "error", err)
+		}
+		fmt.Printf("✅ User %s added\n", os.Args[3])
+
+	case "list":
+		users, err := st.Users()
+		if err != nil {
+			fatal("failed to list users", "error", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tEMAIL\tCREATED")
+		for _, u := range users {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", u.Name, u.Email, u.CreatedAt.Format(time.DateTime))
+		}
+		w.Flush()
+
+	case "remove":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: dr1ll-server users remove <name>")
+			os.Exit(1)
+		}
+		if err := st.RemoveUser(os.Args[3]); err != nil {
+			fatal("failed to remove user", "error", err)
+		}
+		fmt.Printf("✅ User %s removed\n", os.Args[3])
+
+	default:
+		fmt.Printf("Unknown users command: %s\n", os.Args[2])
+		os.Exit(1)
+	}
+}
+
+func tokensCommand() {
+	if len(os.Args) < 3 {
+		fmt.Println("Tokens command required. Available commands:")
+		fmt.Println("  create <name> [options]    Create a token and print its value")
+		fmt.Println("  list                       List tokens")
+		fmt.Println("  revoke <name>              Revoke a token")
+		os.Exit(1)
+	}
+
+	st := storeCommand()
+	defer st.Close()
+
+	switch os.Args[2] {
+	case "create":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: dr1ll-server tokens create <name> [-user <name>] [-bytes-per-day <n>] [-requests-per-month <n>] [-max-tunnels <n>]")
+			os.Exit(1)
+		}
+		fs := flag.NewFlagSet("tokens create", flag.ExitOnError)
+		user := fs.String("user", "", "User owning the token")
+		bytesPerDay := fs.Int64("bytes-per-day", 0, "Daily byte quota (0 = unlimited)")
+		requestsPerMonth := fs.Int64("requests-per-month", 0, "Monthly request quota (0 = unlimited)")
+		maxTunnels := fs.Int("max-tunnels", 0, "Max concurrent tunnels (0 = unlimited)")
+		fs.Parse(os.Args[4:])
+
+		value, err := st.CreateToken(os.Args[3], *user, server.Quota{
+			BytesPerDay:      *bytesPerDay,
+			RequestsPerMonth: *requestsPerMonth,
+			MaxTunnels:       *maxTunnels,
+		})
+		if err != nil {
+			fatal("failed to create token", "error", err)
+		}
+		fmt.Printf("✅ Token %s created. Store it now, it will not be shown again:\n%s\n", os.Args[3], value)
+
+	case "list":
+		tokens, err := st.Tokens()
+		if err != nil {
+			fatal("failed to list tokens", "error", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tUSER\tBYTES/DAY\tREQUESTS/MONTH\tMAX TUNNELS\tCREATED")
+		for _, t := range tokens {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				t.Name, t.Owner,
+				limitString(t.Quota.BytesPerDay, formatBytes),
+				limitString(t.Quota.RequestsPerMonth, func(n int64) string { return fmt.Sprint(n) }),
+				limitString(int64(t.Quota.MaxTunnels), func(n int64) string { return fmt.Sprint(n) }),
+				t.CreatedAt.Format(time.DateTime))
+		}
+		w.Flush()
+
+	case "revoke":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: dr1ll-server tokens revoke <name>")
+			os.Exit(1)
+		}
+		if err := st.RevokeToken(os.Args[3]); err != nil {
+			fatal("failed to revoke token", "error", err)
+		}
+		fmt.Printf("✅ Token %s revoked\n", os.Args[3])
+
+	default:
+		fmt.Printf("Unknown tokens command: %s\n", os.Args[2])
+		os.Exit(1)
+	}
+}
+
+func namesCommand() {
+	if len(os.Args) < 3 {
+		fmt.Println("Names command required. Available commands:")
+		fmt.Println("  reserve <subdomain> <token-name>    Keep a subdomain for one token")
+		fmt.Println("  list                                List reserved subdomains")
+		fmt.Println("  release <subdomain>                 Drop a reservation")
+		os.Exit(1)
+	}
+
+	st := storeCommand()
+	defer st.Close()
+
+	switch os.Args[2] {
+	case "reserve":
+		if len(os.Args) < 5 {
+			fmt.Println("Usage: dr1ll-server names reserve <subdomain> <token-name>")
+			os.Exit(1)
+		}
+		if err := st.Reserve(os.Args[3], os.Args[4]); err != nil {
+			fatal("failed to reserve subdomain", "error", err)
+		}
+		fmt.Printf("✅ %s reserved for token %s\n", os.Args[3], os.Args[4])
+
+	case "list":
+		reservations, err := st.Reservations()
+		if err != nil {
+			fatal("failed to list reservations", "error", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SUBDOMAIN\tTOKEN\tCREATED")
+		for _, r := range reservations {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", r.Subdomain, r.Token, r.CreatedAt.Format(time.DateTime))
+		}
+		w.Flush()
+
+	case "release":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: dr1ll-server names release <subdomain>")
+			os.Exit(1)
+		}
+		if err := st.Unreserve(os.Args[3]); err != nil {
+			fatal("failed to release subdomain", "error", err)
+		}
+		fmt.Printf("✅ %s released\n", os.Args[3])
+
+	default:
+		fmt.Printf("Unknown names command: %s\n", os.Args[2])
+		os.Exit(1)
+	}
+}
+
+func domainsCommand() {
+	if len(os.Args) < 3 {
+		fmt.Println("Domains command required. Available commands:")
+		fmt.Println("  add <host> <subdomain> <token-name>    Route a custom domain to a tunnel")
+		fmt.Println("  list                                   List custom domains")
+		fmt.Println("  remove <host>                          Remove a custom domain")
+		os.Exit(1)
+	}
+
+	st := storeCommand()
+	defer st.Close()
+
+	switch os.Args[2] {
+	case "add":
+		if len(os.Args) < 6 {
+			fmt.Println("Usage: dr1ll-server domains add <host> <subdomain> <token-name>")
+			os.Exit(1)
+		}
+		if err := st.AddDomain(os.Args[3], os.Args[4], os.Args[5]); err != nil {
+			fatal("failed to add domain", "error", err)
+		}
+		fmt.Printf("✅ %s now routes to %s (token %s)\n", os.Args[3], os.Args[4], os.Args[5])
+		fmt.Println("💡 Point its DNS at this server")
+
+	case "list":
+		domains, err := st.Domains()
+		if err != nil {
+			fatal("failed to list domains", "error", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "HOST\tSUBDOMAIN\tTOKEN\tCREATED")
+		for _, d := range domains {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Host, d.Subdomain, d.Token, d.CreatedAt.Format(time.DateTime))
+		}
+		w.Flush()
+
+	case "remove":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: dr1ll-server domains remove <host>")
+			os.Exit(1)
+		}
+		if err := st.RemoveDomain(os.Args[3]); err != nil {
+			fatal("failed to remove domain", "error", err)
+		}
+		fmt.Printf("✅ %s removed\n", os.Args[3])
+
+	default:
+		fmt.Printf("Unknown domains command: %s\n", os.Args[2])
+		os.Exit(1)
+	}
+}
+
+func limitString(limit int64, format func(int64) string) string {
+	if limit <= 0 {
+		return "unlimited"
+	}
+	return format(limit)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
 
 func configCommand() {
@@ -137,7 +1036,9 @@ func configCommand() {
 		fmt.Println("  set-domain <domain>    Set server domain")
 		fmt.Println("  set-port <port>        Set server port")
 		fmt.Println("  set-token <token>      Set authentication token")
-		fmt.Println("  show                   Show current configuration")
+		fmt.Println("  show [--resolved]      Show current configuration, or every setting and its source")
+		fmt.Println("  encrypt                Encrypt stored secrets with DR1LL_PASSPHRASE or DR1LL_KEY_FILE")
+		fmt.Println("  decrypt                Store secrets in plain text again")
 		os.Exit(1)
 	}
 
@@ -151,7 +1052,7 @@ func configCommand() {
 		}
 		domain := os.Args[3]
 		if err := config.SetServerDomain(domain); err != nil {
-			log.Fatalf("Failed to set server domain: %v", err)
+			fatal("failed to set server domain", "error", err)
 		}
 		fmt.Printf("✅ Server domain set to: %s\n", domain)
 
@@ -162,7 +1063,7 @@ func configCommand() {
 		}
 		port := os.Args[3]
 		if err := config.SetServerPort(port); err != nil {
-			log.Fatalf("Failed to set server port: %v", err)
+			fatal("failed to set server port", "error", err)
 		}
 		fmt.Printf("✅ Server port set to: %s\n", port)
 
@@ -173,18 +1074,29 @@ func configCommand() {
 		}
 		token := os.Args[3]
 		if err := config.SetServerToken(token); err != nil {
-			log.Fatalf("Failed to set server token: %v", err)
+			fatal("failed to set server token", "error", err)
 		}
 		fmt.Println("✅ Server authentication token updated")
 
+	case "encrypt", "decrypt":
+		if err := config.SetServerEncryption(subcommand == "encrypt"); err != nil {
+			fatal("failed to "+subcommand+" secrets", "error", err)
+		}
+		fmt.Printf("✅ Secrets %sed\n", subcommand)
+
 	case "show":
-		cfg, err := config.Load()
-		if err != nil {
-			log.Fatalf("Failed to load configuration: %v", err)
+		if len(os.Args) > 3 && strings.TrimLeft(os.Args[3], "-") == "resolved" {
+			showResolved(serverResolver(nil, config.ServerOptions()))
+			return
 		}
-		
-		configPath, _ := config.GetConfigPath()
+
+		cfg := loadConfig()
+
+		configPath, _ := config.GetServerConfigPath()
 		fmt.Printf("Configuration file: %s\n", configPath)
+		if cfg.Encrypted() {
+			fmt.Println("Secret encryption: on")
+		}
 		fmt.Printf("Server domain: %s\n", cfg.ServerDomain)
 		fmt.Printf("Server port: %s\n", cfg.ServerPort)
 		if cfg.ServerToken != "" {
@@ -192,6 +1104,21 @@ func configCommand() {
 		} else {
 			fmt.Println("Server token: (not set)")
 		}
+		if cfg.ServerAdminAddr != "" {
+			fmt.Printf("Admin address: %s\n", cfg.ServerAdminAddr)
+		}
+		if len(cfg.ServerTrustedProxies) > 0 {
+			fmt.Printf("Trusted proxies: %s\n", strings.Join(cfg.ServerTrustedProxies, ", "))
+		}
+		if len(cfg.ServerAllowIPs) > 0 {
+			fmt.Printf("Allowed IPs: %s\n", strings.Join(cfg.ServerAllowIPs, ", "))
+		}
+		if len(cfg.ServerDenyIPs) > 0 {
+			fmt.Printf("Denied IPs: %s\n", strings.Join(cfg.ServerDenyIPs, ", "))
+		}
+		if cfg.ServerOIDC != nil {
+			fmt.Printf("OIDC issuer: %s\n", cfg.ServerOIDC.IssuerURL)
+		}
 
 	default:
 		fmt.Printf("Unknown config command: %s\n", subcommand)
@@ -199,9 +1126,40 @@ func configCommand() {
 	}
 }
 
+// showResolved prints every setting, its effective value and where the
+// value came from.
+func showResolved(opts *config.Resolver) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, s := range opts.Settings() {
+		source := string(s.Source)
+		if s.Origin != "" {
+			source += " (" + s.Origin + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Key, s.Display(), source)
+	}
+	w.Flush()
+}
+
+// quiet suppresses the decorative banners printed by the start command.
+var quiet bool
+
+// banner prints a human-readable status line unless running quietly.
+func banner(format string, a ...any) {
+	if !quiet {
+		fmt.Printf(format, a...)
+	}
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
 	}
 	return b
-}
\ No newline at end of file
+}
@@ -1,44 +1,33 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/M1z23R/dr1ll/internal/client"
 	"github.com/M1z23R/dr1ll/internal/config"
-	"golang.org/x/sys/windows/svc"
+	"github.com/M1z23R/dr1ll/internal/logging"
+	"github.com/M1z23R/dr1ll/internal/systemd"
+	"github.com/M1z23R/dr1ll/internal/tracing"
 )
 
-type DrillService struct {
-	RunFunc func()
-}
-
-func (m *DrillService) Execute(args []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (bool, uint32) {
-	s <- svc.Status{State: svc.StartPending}
-	s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
-
-	go m.RunFunc()
-	for c := range r {
-		switch c.Cmd {
-		case svc.Interrogate:
-			s <- c.CurrentStatus
-		case svc.Stop, svc.Shutdown:
-			log.Println("Service stopping")
-			return false, 0
-		}
-	}
-	return false, 0
-}
-
 func main() {
-	isService, err := svc.IsWindowsService()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if isService {
-		svc.Run("DrillService", &DrillService{RunFunc: startCommand})
+	if runAsService() {
 		return
 	}
 
@@ -54,6 +43,14 @@ func main() {
 		startCommand()
 	case "config":
 		configCommand()
+	case "service":
+		serviceCommand()
+	case "status":
+		statusCommand()
+	case "ls":
+		lsCommand()
+	case "stop":
+		stopCommand()
 	case "help", "-h", "--help":
 		showUsage()
 	default:
@@ -68,76 +65,525 @@ func showUsage() {
 	fmt.Println("")
 	fmt.Println("Usage:")
 	fmt.Println("  dr1ll start [options]    Start the tunnel client")
+	fmt.Println("  dr1ll start [options] <name>...  Start tunnels declared in dr1ll.yaml")
 	fmt.Println("  dr1ll config <command>   Manage configuration")
+	fmt.Println("  dr1ll service <command>  Run tunnels in the background as a systemd user service (Linux)")
+	fmt.Println("  dr1ll status             Show the URL, state, uptime and requests of running tunnels")
+	fmt.Println("  dr1ll ls                 List running tunnels")
+	fmt.Println("  dr1ll stop <name>        Stop a running tunnel by name, subdomain or URL")
 	fmt.Println("  dr1ll help              Show this help message")
 	fmt.Println("")
 	fmt.Println("Start options:")
 	fmt.Println("  -port <port>            Local port to forward (default: 3000)")
 	fmt.Println("  -server <url>           Override tunnel server URL")
 	fmt.Println("  -token <token>          Override authentication token")
+	fmt.Println("  -profile <name>         Use this config profile instead of the current one")
+	fmt.Println("  -file <path>            Tunnels file (default: ./dr1ll.yaml, then ~/.config/dr1ll/dr1ll.yaml)")
 	fmt.Println("  -subdomain <name>       Request specific subdomain")
+	fmt.Println("  -auth <user:pass>       Require HTTP Basic auth on the public URL")
+	fmt.Println("  -bearer <token>         Require a bearer token on the public URL")
+	fmt.Println("  -oidc                   Require signing in via the server's OIDC provider")
+	fmt.Println("  -oidc-domains <list>    Only allow these comma-separated email domains")
+	fmt.Println("  -oidc-groups <list>     Only allow members of these comma-separated groups")
+	fmt.Println("  -allow-ips <list>       Only allow these comma-separated CIDRs")
+	fmt.Println("  -deny-ips <list>        Block these comma-separated CIDRs")
+	fmt.Println("  -max-concurrency <n>    Max requests forwarded at once, excess are queued (default: unlimited)")
+	fmt.Println("  -inspect                Log the headers and bodies of tunneled requests and responses")
+	fmt.Println("  -drain-timeout <d>      How long Ctrl+C waits for in-flight requests (default: 30s)")
+	fmt.Println("  -log-level <level>      Log level: debug, info, warn, error (default: info)")
+	fmt.Println("  -log-format <format>    Log format: text or json (default: text)")
+	fmt.Println("  -quiet                  Suppress banners, only emit logs")
+	fmt.Println("  -otlp-endpoint <url>    Export traces to this OTLP/HTTP collector (e.g. http://localhost:4318)")
+	fmt.Println("")
+	fmt.Println("Named tunnels (dr1ll.yaml):")
+	fmt.Println("  tunnels:")
+	fmt.Println("    api:")
+	fmt.Println("      upstream: localhost:8080        # port, host:port or http(s) URL")
+	fmt.Println("      subdomain: myapi")
+	fmt.Println("      auth:")
+	fmt.Println("        basic: user:pass")
+	fmt.Println("        oidc: {domains: [example.com]}")
+	fmt.Println("        allow_ips: [10.0.0.0/8]")
+	fmt.Println("      headers:")
+	fmt.Println("        request: {set: {X-Env: dev}, remove: [Cookie]}")
+	fmt.Println("        response: {set: {Cache-Control: no-store}}")
+	fmt.Println("      inspect: {enabled: true, max_body_size: 4096}")
+	fmt.Println("    web:")
+	fmt.Println("      upstream: 3000")
+	fmt.Println("")
+	fmt.Println("Tunnel options such as -port and -auth apply to a single unnamed tunnel;")
+	fmt.Println("named tunnels take them from the file.")
 	fmt.Println("")
 	fmt.Println("Config commands:")
-	fmt.Println("  dr1ll config set-server <url>    Set tunnel server URL")
-	fmt.Println("  dr1ll config set-token <token>   Set authentication token")
-	fmt.Println("  dr1ll config show                Show current configuration")
+	fmt.Println("  dr1ll config set-server <url> [-profile <name>]     Set tunnel server URL")
+	fmt.Println("  dr1ll config set-token <token> [-profile <name>]   Set authentication token")
+	fmt.Println("  dr1ll config use-profile <name>                    Switch the current profile")
+	fmt.Println("  dr1ll config profiles                              List profiles")
+	fmt.Println("  dr1ll config show [-profile <name>] [--resolved]   Show configuration, or every setting with its source")
+	fmt.Println("  dr1ll config doctor [-profile <name>]              Check the configuration and server")
+	fmt.Println("  dr1ll config encrypt                               Encrypt stored tokens")
+	fmt.Println("  dr1ll config decrypt                               Store tokens in plain text again")
+	fmt.Println("")
+	fmt.Println("status, ls and stop talk to running clients over control sockets in")
+	fmt.Println("$XDG_RUNTIME_DIR/dr1ll, or ~/.config/dr1ll/run when it is not set.")
+	fmt.Println("")
+	fmt.Println("Service commands (Linux):")
+	fmt.Println("  dr1ll service install [-name <name>] [start options] [<tunnel>...]")
+	fmt.Println("                              Write ~/.config/systemd/user/<name>.service running")
+	fmt.Println("                              'dr1ll start' from the current directory, and start it")
+	fmt.Println("  dr1ll service uninstall [-name <name>]   Stop and remove the service")
+	fmt.Println("  dr1ll service status [-name <name>]      Show the service status")
+	fmt.Println("The unit uses Type=notify: it is ready once every tunnel is active, and a")
+	fmt.Println("watchdog restarts a hung client. -name defaults to dr1ll. DR1LL_* variables")
+	fmt.Println("are saved to ~/.config/dr1ll/<name>.env, readable only by you; -token, -auth")
+	fmt.Println("and -bearer are refused since the unit file is world-readable.")
+	fmt.Println("")
+	fmt.Println("Without -profile, config commands act on the current profile.")
+	fmt.Println("Configuration is kept in ~/.config/dr1ll/client.json, readable only by you.")
+	fmt.Println("Encrypted tokens are decrypted with the passphrase in DR1LL_PASSPHRASE or")
+	fmt.Println("the key file named by DR1LL_KEY_FILE, which must be set whenever they are used.")
+	fmt.Println("")
+	fmt.Println("Configuration priority (highest to lowest):")
+	fmt.Println("  1. Command line flags")
+	fmt.Println("  2. Environment variables")
+	fmt.Println("  3. Project file (./dr1ll.yaml): top-level profile, tunnel_server and token keys")
+	fmt.Println("  4. User file (the selected profile in ~/.config/dr1ll/client.json)")
+	fmt.Println("  5. Built-in defaults")
+	fmt.Println("  A profile's token is only sent to that profile's server; when the server")
+	fmt.Println("  comes from another layer, pass the token with -token or DR1LL_TOKEN.")
+	fmt.Println("")
+	fmt.Println("Environment variables:")
+	fmt.Println("  DR1LL_PROFILE           Config profile")
+	fmt.Println("  DR1LL_TUNNEL_SERVER     Tunnel server URL")
+	fmt.Println("  DR1LL_TOKEN             Authentication token")
+	fmt.Println("  DR1LL_DRAIN_TIMEOUT     How long Ctrl+C waits for in-flight requests")
+	fmt.Println("  DR1LL_LOG_LEVEL         Log level")
+	fmt.Println("  DR1LL_LOG_FORMAT        Log format")
+	fmt.Println("  DR1LL_OTLP_ENDPOINT     OTLP/HTTP collector URL (OTEL_EXPORTER_OTLP_ENDPOINT also works)")
+}
+
+// tunnelFlags are the start options describing a single tunnel; named
+// tunnels take these from the tunnels file instead.
+var tunnelFlags = []string{
+	"port", "subdomain", "auth", "bearer", "oidc", "oidc-domains", "oidc-groups",
+	"allow-ips", "deny-ips", "max-concurrency", "inspect",
 }
 
 func startCommand() {
 	startArgs := os.Args[2:]
 
 	fs := flag.NewFlagSet("start", flag.ExitOnError)
+	options := config.ClientOptions()
+	config.RegisterFlags(fs, options)
 	port := fs.Int("port", 3000, "Local port to forward requests to")
-	serverURL := fs.String("server", "", "Tunnel server URL (overrides config)")
-	token := fs.String("token", "", "Authentication token (overrides config)")
+	tunnelsFile := fs.String("file", "", "Tunnels file (default: ./dr1ll.yaml, then ~/.config/dr1ll/dr1ll.yaml)")
 	subdomain := fs.String("subdomain", "", "Request specific subdomain")
+	basicAuth := fs.String("auth", "", "Require HTTP Basic auth (user:pass) on the public URL")
+	bearer := fs.String("bearer", "", "Require a bearer token on the public URL")
+	oidcLogin := fs.Bool("oidc", false, "Require signing in via the server's OIDC provider")
+	oidcDomains := fs.String("oidc-domains", "", "Comma-separated email domains allowed to sign in")
+	oidcGroups := fs.String("oidc-groups", "", "Comma-separated groups allowed to sign in")
+	allowIPs := fs.String("allow-ips", "", "Comma-separated CIDRs allowed to reach the tunnel")
+	denyIPs := fs.String("deny-ips", "", "Comma-separated CIDRs blocked from the tunnel")
+	maxConcurrency := fs.Int("max-concurrency", 0, "Max requests forwarded at once (0 = unlimited)")
+	inspect := fs.Bool("inspect", false, "Log the headers and bodies of tunneled requests and responses")
+	fs.BoolVar(&quiet, "quiet", false, "Suppress banners, only emit logs")
 
 	fs.Parse(startArgs)
+	opts := clientResolver(fs, options)
 
-	cfg, err := config.Load()
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+	if err := logging.Setup(opts.Get("log_level"), opts.Get("log_format")); err != nil {
+		fatal("invalid logging options", "error", err)
 	}
 
-	finalServerURL := cfg.TunnelServer
-	if *serverURL != "" {
-		finalServerURL = *serverURL
+	shutdownTracing, err := tracing.Setup(context.Background(), "dr1ll", opts.Get("otlp_endpoint"))
+	if err != nil {
+		fatal("failed to set up tracing", "error", err)
 	}
+	defer shutdownTracing(context.Background())
 
-	finalToken := cfg.Token
-	if *token != "" {
-		finalToken = *token
+	drainTimeout, err := time.ParseDuration(opts.Get("drain_timeout"))
+	if err != nil {
+		fatal("invalid drain timeout", "error", err)
 	}
 
+	finalServerURL := opts.Get("tunnel_server")
+	finalToken := opts.Get("token")
+	profileName := opts.Get("profile")
+
 	if finalServerURL == "" {
-		log.Fatal("No tunnel server URL configured. Use 'dr1ll config set-server <url>' to set one.")
+		fatal("no tunnel server URL configured, use 'dr1ll config set-server <url>' to set one")
 	}
 
 	if finalToken == "" {
-		log.Fatal("No authentication token configured. Use 'dr1ll config set-token <token>' to set one.")
+		fatal("no authentication token configured, use 'dr1ll config set-token <token>' to set one")
+	}
+
+	tunnels := make(map[string]*config.TunnelSettings)
+	if names := fs.Args(); len(names) > 0 {
+		fs.Visit(func(f *flag.Flag) {
+			if slices.Contains(tunnelFlags, f.Name) {
+				fatal("tunnel options cannot be combined with named tunnels, set them in "+config.TunnelsFileName, "flag", f.Name)
+			}
+		})
+		tunnels = loadNamedTunnels(*tunnelsFile, names)
+	} else {
+		settings := &config.TunnelSettings{
+			Upstream:       fmt.Sprintf("localhost:%d", *port),
+			Subdomain:      *subdomain,
+			MaxConcurrency: *maxConcurrency,
+		}
+		if *basicAuth != "" || *bearer != "" || *oidcLogin || *oidcDomains != "" || *oidcGroups != "" || *allowIPs != "" || *denyIPs != "" {
+			settings.Auth = &config.TunnelAuth{
+				Basic:    *basicAuth,
+				Bearer:   *bearer,
+				AllowIPs: splitList(*allowIPs),
+				DenyIPs:  splitList(*denyIPs),
+			}
+			if *oidcLogin || *oidcDomains != "" || *oidcGroups != "" {
+				settings.Auth.OIDC = &config.TunnelOIDC{Domains: splitList(*oidcDomains), Groups: splitList(*oidcGroups)}
+			}
+		}
+		if *inspect {
+			settings.Inspect = &config.InspectSettings{Enabled: true}
+		}
+		tunnels[""] = settings
+	}
+
+	banner("🌐 Server: %s\n", finalServerURL)
+	if profileName != config.DefaultProfile {
+		banner("👤 Profile: %s\n", profileName)
+	}
+
+	clients := make(map[string]*client.Client, len(tunnels))
+	for name, settings := range tunnels {
+		c, err := newTunnelClient(finalServerURL, finalToken, name, settings)
+		if err != nil {
+			fatal("invalid tunnel settings", "tunnel", name, "error", err)
+		}
+		c.SetDrainTimeout(drainTimeout)
+		c.SetName(name)
+		clients[name] = c
+	}
+	notifySystemd(clients)
+	ctl := startControl(clients)
+
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	for name, c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Run(); err != nil {
+				slog.Error("tunnel failed", "tunnel", name, "error", err)
+				failed.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+	if ctl != nil {
+		ctl.Close()
+	}
+
+	if failed.Load() {
+		os.Exit(1)
+	}
+	banner("👋 Tunnel closed. Goodbye!\n")
+}
+
+// notifySystemd tells systemd the service is ready once every tunnel is
+// active, feeds its watchdog while the tunnels are alive and reports when
+// shutdown starts. Outside a systemd service it does nothing.
+func notifySystemd(clients map[string]*client.Client) {
+	notify := func(state string) {
+		if err := systemd.Notify(state); err != nil {
+			slog.Warn("failed to notify systemd", "error", err)
+		}
+	}
+
+	// Reconnects call OnActive again, so count each tunnel once.
+	var mu sync.Mutex
+	active := make(map[string]bool)
+	for name, c := range clients {
+		c.SetOnActive(func(string) {
+			mu.Lock()
+			defer mu.Unlock()
+			if active[name] {
+				return
+			}
+			active[name] = true
+			if len(active) == len(clients) {
+				notify(fmt.Sprintf("READY=1\nSTATUS=%d tunnel(s) active", len(clients)))
+			}
+		})
+	}
+
+	// A hung tunnel starves the watchdog so systemd restarts the client.
+	// Tunnels stopped through the control socket are left out.
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		go func() {
+			for range time.Tick(interval / 2) {
+				alive := true
+				for _, c := range clients {
+					if !c.Alive() && c.Status().State != client.StateClosed {
+						alive = false
+					}
+				}
+				if alive {
+					notify("WATCHDOG=1")
+				}
+			}
+		}()
+	}
+
+	stopping := make(chan os.Signal, 1)
+	signal.Notify(stopping, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stopping
+		notify("STOPPING=1")
+	}()
+}
+
+// startControl serves the control API for clients on a socket named after
+// this process, so status, ls and stop can find them. Failing to do so only
+// costs those commands, so it is not fatal.
+func startControl(clients map[string]*client.Client) *client.Control {
+	dir, err := config.GetControlDir()
+	if err != nil {
+		slog.Warn("control socket unavailable", "error", err)
+		return nil
+	}
+	ctl, err := client.ListenControl(filepath.Join(dir, fmt.Sprintf("%d.sock", os.Getpid())))
+	if err != nil {
+		slog.Warn("control socket unavailable", "error", err)
+		return nil
+	}
+	for _, c := range clients {
+		ctl.Add(c)
+	}
+	go func() {
+		if err := ctl.Serve(); err != nil {
+			slog.Warn("control socket stopped", "error", err)
+		}
+	}()
+	return ctl
+}
+
+// controlSockets returns the control sockets of running clients, removing
+// those left behind by processes that are gone.
+func controlSockets() []string {
+	dir, err := config.GetControlDir()
+	if err != nil {
+		fatal("failed to locate control sockets", "error", err)
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.sock"))
+
+	var live []string
+	for _, path := range paths {
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err != nil {
+			// Only a socket nobody listens on is stale; a busy client
+			// that is slow to accept keeps its socket.
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				os.Remove(path)
+			}
+			continue
+		}
+		conn.Close()
+		live = append(live, path)
+	}
+	return live
+}
+
+// runningTunnels collects the status of the tunnels of every running
+// client.
+func runningTunnels() []client.Status {
+	var tunnels []client.Status
+	for _, path := range controlSockets() {
+		statuses, err := client.ControlStatus(path)
+		if err != nil {
+			slog.Warn("failed to query client", "socket", path, "error", err)
+			continue
+		}
+		tunnels = append(tunnels, statuses...)
+	}
+	return tunnels
+}
+
+// tunnelLabel returns the name a tunnel is shown and stopped by: its name
+// in dr1ll.yaml, or else its subdomain.
+func tunnelLabel(s client.Status) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	if subdomain, _, ok := strings.Cut(s.PublicURL, "."); ok {
+		return subdomain
+	}
+	return "-"
+}
+
+// uptime returns how long a tunnel has been connected, or "-" if it is not.
+func uptime(s client.Status) string {
+	if s.State != client.StateActive || s.ConnectedAt.IsZero() {
+		return "-"
+	}
+	return time.Since(s.ConnectedAt).Round(time.Second).String()
+}
+
+func lsCommand() {
+	tunnels := runningTunnels()
+	if len(tunnels) == 0 {
+		fmt.Println("No tunnels running")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tURL\tSTATE\tUPTIME\tREQUESTS\tPID")
+	for _, t := range tunnels {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\n", tunnelLabel(t), t.PublicURL, t.State, uptime(t), t.Requests, t.PID)
+	}
+	w.Flush()
+}
+
+func statusCommand() {
+	tunnels := runningTunnels()
+	if len(tunnels) == 0 {
+		fmt.Println("No tunnels running")
+		return
+	}
+
+	for i, t := range tunnels {
+		if i > 0 {
+			fmt.Println()
+		}
+		icon := "🟡"
+		if t.State == client.StateActive {
+			icon = "🟢"
+		}
+		fmt.Printf("%s %s  %s\n", icon, tunnelLabel(t), t.PublicURL)
+		fmt.Printf("   State:     %s (pid %d)\n", t.State, t.PID)
+		fmt.Printf("   Upstream:  %s\n", t.Upstream)
+		fmt.Printf("   Server:    %s\n", t.Server)
+		fmt.Printf("   Uptime:    %s (started %s)\n", uptime(t), t.StartedAt.Format(time.DateTime))
+		fmt.Printf("   Requests:  %d (%d in flight, %d failed)\n", t.Requests, t.InFlight, t.Errors)
+	}
+}
+
+func stopCommand() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: dr1ll stop <name>")
+		os.Exit(1)
+	}
+	name := os.Args[2]
+
+	for _, path := range controlSockets() {
+		err := client.ControlStop(path, name)
+		if errors.Is(err, client.ErrTunnelNotFound) {
+			continue
+		}
+		if err != nil {
+			fatal("failed to stop tunnel", "tunnel", name, "error", err)
+		}
+		fmt.Printf("✅ Stopping tunnel %s\n", name)
+		return
+	}
+	fatal("no running tunnel by that name, see 'dr1ll ls'", "tunnel", name)
+}
+
+// loadNamedTunnels looks up the named tunnels in the tunnels file.
+func loadNamedTunnels(path string, names []string) map[string]*config.TunnelSettings {
+	if path == "" {
+		found, err := config.FindTunnelsFile()
+		if err != nil {
+			fatal("failed to find tunnels file", "error", err)
+		}
+		path = found
+	}
+
+	file, err := config.LoadTunnels(path)
+	if err != nil {
+		fatal("failed to load tunnels file", "error", err)
 	}
 
-	fmt.Printf("🏠 Starting tunnel client for localhost:%d\n", *port)
-	fmt.Printf("🌐 Server: %s\n", finalServerURL)
+	tunnels := make(map[string]*config.TunnelSettings, len(names))
+	for _, name := range names {
+		settings, err := file.Tunnel(name)
+		if err != nil {
+			fatal("unknown tunnel", "tunnel", name, "file", path, "defined", strings.Join(file.Names(), ", "))
+		}
+		tunnels[name] = settings
+	}
+	return tunnels
+}
 
-	client := client.NewClient(finalServerURL, finalToken, *port)
-	if *subdomain != "" {
-		client.SetRequestedSubdomain(*subdomain)
-		fmt.Printf("🎯 Requesting subdomain: %s\n", *subdomain)
+// newTunnelClient builds a client for one tunnel. name is empty for the
+// tunnel described by flags.
+func newTunnelClient(serverURL, token, name string, settings *config.TunnelSettings) (*client.Client, error) {
+	prefix := ""
+	if name != "" {
+		prefix = "[" + name + "] "
 	}
-	if err := client.Run(); err != nil {
-		log.Fatal(err)
+
+	c := client.NewClient(serverURL, token, 0)
+	c.SetQuiet(quiet)
+	if err := c.SetUpstream(settings.Upstream); err != nil {
+		return nil, err
 	}
+	banner("🏠 %sStarting tunnel client for %s\n", prefix, settings.Upstream)
 
-	fmt.Println("👋 Tunnel closed. Goodbye!")
+	if settings.Subdomain != "" {
+		c.SetRequestedSubdomain(settings.Subdomain)
+		banner("🎯 %sRequesting subdomain: %s\n", prefix, settings.Subdomain)
+	}
+	if auth := settings.Auth; auth != nil {
+		if auth.Basic != "" {
+			username, password, ok := strings.Cut(auth.Basic, ":")
+			if !ok || username == "" {
+				return nil, errors.New("invalid basic auth value, expected user:pass")
+			}
+			c.SetBasicAuth(username, password)
+			banner("🔒 %sBasic auth required for user: %s\n", prefix, username)
+		}
+		if auth.Bearer != "" {
+			c.SetBearerToken(auth.Bearer)
+			banner("🔒 %sBearer token required\n", prefix)
+		}
+		if auth.OIDC != nil {
+			c.SetOIDCLogin(auth.OIDC.Domains, auth.OIDC.Groups)
+			banner("🔒 %sOIDC sign-in required\n", prefix)
+		}
+		if len(auth.AllowIPs) > 0 || len(auth.DenyIPs) > 0 {
+			c.SetIPRules(auth.AllowIPs, auth.DenyIPs)
+			banner("🛡️  %sIP rules applied\n", prefix)
+		}
+	}
+	if headers := settings.Headers; headers != nil {
+		c.SetHeaderRules(
+			client.HeaderRules{Set: headers.Request.Set, Remove: headers.Request.Remove},
+			client.HeaderRules{Set: headers.Response.Set, Remove: headers.Response.Remove},
+		)
+	}
+	if settings.Inspect != nil && settings.Inspect.Enabled {
+		c.SetInspect(client.InspectOptions{MaxBodySize: settings.Inspect.MaxBodySize})
+		banner("🔍 %sInspecting requests\n", prefix)
+	}
+	if settings.MaxConcurrency > 0 {
+		c.SetMaxConcurrency(settings.MaxConcurrency)
+	}
+	return c, nil
 }
 
 func configCommand() {
 	if len(os.Args) < 3 {
 		fmt.Println("Config command required. Available commands:")
-		fmt.Println("  set-server <url>    Set tunnel server URL")
-		fmt.Println("  set-token <token>   Set authentication token")
-		fmt.Println("  show               Show current configuration")
+		fmt.Println("  set-server <url> [-profile <name>]     Set tunnel server URL")
+		fmt.Println("  set-token <token> [-profile <name>]   Set authentication token")
+		fmt.Println("  use-profile <name>                    Switch the current profile")
+		fmt.Println("  profiles                              List profiles")
+		fmt.Println("  show [-profile <name>] [--resolved]   Show configuration, or every setting and its source")
+		fmt.Println("  doctor [-profile <name>]              Check the configuration and server")
+		fmt.Println("  encrypt                               Encrypt stored tokens with DR1LL_PASSPHRASE or DR1LL_KEY_FILE")
+		fmt.Println("  decrypt                               Store tokens in plain text again")
 		os.Exit(1)
 	}
 
@@ -146,40 +592,95 @@ func configCommand() {
 	switch subcommand {
 	case "set-server":
 		if len(os.Args) < 4 {
-			fmt.Println("Usage: dr1ll config set-server <url>")
+			fmt.Println("Usage: dr1ll config set-server <url> [-profile <name>]")
 			os.Exit(1)
 		}
 		serverURL := os.Args[3]
-		if err := config.SetServer(serverURL); err != nil {
-			log.Fatalf("Failed to set server URL: %v", err)
+		profile := profileFlag(os.Args[4:])
+		if err := config.SetServer(profile, serverURL); err != nil {
+			fatal("failed to set server URL", "error", err)
 		}
 		fmt.Printf("✅ Tunnel server set to: %s\n", serverURL)
 
 	case "set-token":
 		if len(os.Args) < 4 {
-			fmt.Println("Usage: dr1ll config set-token <token>")
+			fmt.Println("Usage: dr1ll config set-token <token> [-profile <name>]")
 			os.Exit(1)
 		}
 		token := os.Args[3]
-		if err := config.SetToken(token); err != nil {
-			log.Fatalf("Failed to set token: %v", err)
+		profile := profileFlag(os.Args[4:])
+		if err := config.SetToken(profile, token); err != nil {
+			fatal("failed to set token", "error", err)
 		}
 		fmt.Println("✅ Authentication token updated")
 
+	case "use-profile":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: dr1ll config use-profile <name>")
+			os.Exit(1)
+		}
+		name := os.Args[3]
+		if err := config.UseProfile(name); err != nil {
+			fatal("failed to switch profile", "error", err)
+		}
+		fmt.Printf("✅ Now using profile: %s\n", name)
+
+	case "profiles":
+		cfg, err := config.LoadClient()
+		if errors.Is(err, config.ErrNoConfig) {
+			fmt.Println("No profiles configured")
+			return
+		}
+		if err != nil {
+			fatal("failed to load configuration", "error", err)
+		}
+		current, _ := cfg.Profile("")
+		for _, name := range cfg.ProfileNames() {
+			marker := " "
+			if current != nil && current.Name == name {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\t%s\n", marker, name, cfg.Profiles[name].TunnelServer)
+		}
+
+	case "encrypt", "decrypt":
+		if err := config.SetClientEncryption(subcommand == "encrypt"); err != nil {
+			fatal("failed to "+subcommand+" tokens", "error", err)
+		}
+		fmt.Printf("✅ Tokens %sed\n", subcommand)
+
+	case "doctor":
+		if !doctor(profileFlag(os.Args[3:])) {
+			os.Exit(1)
+		}
+
 	case "show":
-		cfg, err := config.Load()
+		fs := flag.NewFlagSet("config show", flag.ExitOnError)
+		profileName := fs.String("profile", "", "Config profile (default: the current profile)")
+		resolved := fs.Bool("resolved", false, "Show every setting and where its value comes from")
+		fs.Parse(os.Args[3:])
+		if *resolved {
+			showResolved(clientResolver(fs, config.ClientOptions()))
+			return
+		}
+
+		profile, err := loadProfile(*profileName)
 		if err != nil {
-			log.Fatalf("Failed to load configuration: %v", err)
+			fatal("failed to load configuration", "error", err)
 		}
 
-		configPath, _ := config.GetConfigPath()
+		configPath, _ := config.GetClientConfigPath()
 		fmt.Printf("Configuration file: %s\n", configPath)
-		fmt.Printf("Tunnel server: %s\n", cfg.TunnelServer)
-		if cfg.Token != "" {
-			fmt.Printf("Token: %s***\n", cfg.Token[:min(len(cfg.Token), 8)])
+		fmt.Printf("Profile: %s\n", profile.Name)
+		fmt.Printf("Tunnel server: %s\n", profile.TunnelServer)
+		if profile.Token != "" {
+			fmt.Printf("Token: %s***\n", profile.Token[:min(len(profile.Token), 8)])
 		} else {
 			fmt.Println("Token: (not set)")
 		}
+		if cfg, err := config.LoadClient(); err == nil && cfg.Encrypted() {
+			fmt.Println("Token encryption: on")
+		}
 
 	default:
 		fmt.Printf("Unknown config command: %s\n", subcommand)
@@ -187,6 +688,143 @@ func configCommand() {
 	}
 }
 
+// doctor checks the client configuration, DNS and a test handshake with
+// the server, printing a line per check. It returns false if any failed.
+func doctor(profileName string) bool {
+	pass := func(format string, a ...any) {
+		fmt.Printf("✅ "+format+"\n", a...)
+	}
+	fail := func(hint, format string, a ...any) bool {
+		fmt.Printf("❌ "+format+"\n", a...)
+		fmt.Printf("   → %s\n", hint)
+		return false
+	}
+
+	configPath, _ := config.GetClientConfigPath()
+	cfg, err := config.LoadClient()
+	if errors.Is(err, config.ErrNoConfig) {
+		return fail("run 'dr1ll config set-server <url>' and 'dr1ll config set-token <token>'", "No configuration at %s", configPath)
+	}
+	if err != nil {
+		return fail("fix the file, or rewrite the values with 'dr1ll config set-server' and 'set-token'", "%v", err)
+	}
+	pass("Configuration file %s is valid", configPath)
+
+	profile, err := cfg.Profile(profileName)
+	if err != nil {
+		return fail("use 'dr1ll config profiles' to list profiles", "%v", err)
+	}
+	pass("Using profile %s", profile.Name)
+
+	ok := true
+	if path, err := config.FindTunnelsFile(); err == nil {
+		if _, err := config.LoadTunnels(path); err != nil {
+			ok = fail("fix the tunnels file", "%v", err)
+		} else {
+			pass("Tunnels file %s is valid", path)
+		}
+	}
+
+	if profile.TunnelServer == "" {
+		return fail("run 'dr1ll config set-server <url>'", "No tunnel server URL set")
+	}
+	if profile.Token == "" {
+		return fail("run 'dr1ll config set-token <token>'", "No authentication token set")
+	}
+
+	u, _ := url.Parse(profile.TunnelServer)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	addrs, err := net.DefaultResolver.LookupHost(ctx, u.Hostname())
+	cancel()
+	if err != nil {
+		return fail("check the server URL and your DNS settings", "Cannot resolve %s: %v", u.Hostname(), err)
+	}
+	pass("%s resolves to %s", u.Hostname(), strings.Join(addrs, ", "))
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	err = client.Probe(ctx, profile.TunnelServer, profile.Token)
+	cancel()
+	if err != nil {
+		return fail("check the server URL, the token and that the server is running", "Handshake failed: %v", err)
+	}
+	pass("Handshake with %s succeeded", profile.TunnelServer)
+
+	return ok
+}
+
+// clientResolver resolves the client's options, exiting on an unreadable
+// config file or unknown profile.
+func clientResolver(fs *flag.FlagSet, options []config.Option) *config.Resolver {
+	opts, err := config.NewClientResolver(fs, options)
+	if err != nil {
+		fatal("failed to load configuration", "error", err)
+	}
+	return opts
+}
+
+// showResolved prints every setting, its effective value and where the
+// value came from.
+func showResolved(opts *config.Resolver) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, s := range opts.Settings() {
+		source := string(s.Source)
+		if s.Origin != "" {
+			source += " (" + s.Origin + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Key, s.Display(), source)
+	}
+	w.Flush()
+}
+
+// profileFlag parses the -profile option of a config subcommand.
+func profileFlag(args []string) string {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	profile := fs.String("profile", "", "Config profile (default: the current profile)")
+	fs.Parse(args)
+	return *profile
+}
+
+// loadProfile loads the named client profile, or the current one if name is
+// empty. On error it still returns an empty profile.
+func loadProfile(name string) (*config.Profile, error) {
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return &config.Profile{}, err
+	}
+	profile, err := cfg.Profile(name)
+	if err != nil {
+		return &config.Profile{}, err
+	}
+	return profile, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// quiet suppresses the decorative banners printed by the start command.
+var quiet bool
+
+// banner prints a human-readable status line unless running quietly.
+func banner(format string, a ...any) {
+	if !quiet {
+		fmt.Printf(format, a...)
+	}
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -0,0 +1,271 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/M1z23R/dr1ll/internal/config"
+)
+
+// defaultUnitName is the systemd unit installed when no -name is given.
+const defaultUnitName = "dr1ll"
+
+// serviceWatchdog is how long systemd waits for a watchdog notification
+// before restarting the client.
+const serviceWatchdog = "30s"
+
+// runAsService reports false: systemd runs the client through the start
+// command written into its unit.
+func runAsService() bool {
+	return false
+}
+
+func serviceCommand() {
+	if len(os.Args) < 3 {
+		fmt.Println("Service command required. Available commands:")
+		fmt.Println("  install [-name <name>] [start options] [<tunnel>...]   Run dr1ll start as a systemd user service")
+		fmt.Println("  uninstall [-name <name>]                               Stop and remove the service")
+		fmt.Println("  status [-name <name>]                                  Show the service status")
+		os.Exit(1)
+	}
+
+	subcommand := os.Args[2]
+	name, args := unitNameFlag(os.Args[3:])
+	unit := name + ".service"
+
+	switch subcommand {
+	case "install":
+		if flag := secretFlag(args); flag != "" {
+			fatal("secrets on the command line end up in the unit file, set the token with DR1LL_TOKEN or 'dr1ll config set-token' and tunnel auth in "+config.TunnelsFileName, "flag", flag)
+		}
+		path, err := unitPath(unit)
+		if err != nil {
+			fatal("failed to locate systemd user directory", "error", err)
+		}
+		envPath, err := envFilePath(name)
+		if err != nil {
+			fatal("failed to locate config directory", "error", err)
+		}
+		if err := writeEnvFile(envPath); err != nil {
+			fatal("failed to write environment file", "error", err)
+		}
+		content, err := serviceUnit(args, envPath)
+		if err != nil {
+			fatal("failed to generate unit", "error", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			fatal("failed to create systemd user directory", "error", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			fatal("failed to write unit", "error", err)
+		}
+		if err := systemctl("daemon-reload"); err != nil {
+			fatal("failed to reload systemd", "error", err)
+		}
+		if err := systemctl("enable", "--now", unit); err != nil {
+			fatal("failed to start service", "unit", unit, "error", err)
+		}
+		fmt.Printf("✅ Installed and started %s (%s)\n", unit, path)
+		fmt.Printf("💡 Logs: journalctl --user -u %s -f\n", unit)
+		fmt.Println("💡 To keep it running while you are logged out: loginctl enable-linger")
+
+	case "uninstall":
+		path, err := unitPath(unit)
+		if err != nil {
+			fatal("failed to locate systemd user directory", "error", err)
+		}
+		if _, err := os.Stat(path); err != nil {
+			fatal("service is not installed", "unit", unit)
+		}
+		if err := systemctl("disable", "--now", unit); err != nil {
+			fatal("failed to stop service", "unit", unit, "error", err)
+		}
+		if err := os.Remove(path); err != nil {
+			fatal("failed to remove unit", "error", err)
+		}
+		if envPath, err := envFilePath(name); err == nil {
+			os.Remove(envPath)
+		}
+		if err := systemctl("daemon-reload"); err != nil {
+			fatal("failed to reload systemd", "error", err)
+		}
+		fmt.Printf("✅ Removed %s\n", unit)
+
+	case "status":
+		err := systemctl("status", "--no-pager", unit)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		if err != nil {
+			fatal("failed to query service", "unit", unit, "error", err)
+		}
+
+	default:
+		fmt.Printf("Unknown service command: %s\n", subcommand)
+		os.Exit(1)
+	}
+}
+
+// unitNameFlag takes a leading -name option off args, leaving the rest to
+// be passed on to the start command.
+func unitNameFlag(args []string) (string, []string) {
+	if len(args) >= 2 && (args[0] == "-name" || args[0] == "--name") {
+		return args[1], args[2:]
+	}
+	if len(args) >= 1 {
+		for _, prefix := range []string{"-name=", "--name="} {
+			if name, ok := strings.CutPrefix(args[0], prefix); ok {
+				return name, args[1:]
+			}
+		}
+	}
+	return defaultUnitName, args
+}
+
+// secretFlag returns the first option in args that carries a secret, or ""
+// if there is none.
+func secretFlag(args []string) string {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && slices.Contains([]string{"token", "auth", "bearer"}, name) {
+			return "-" + name
+		}
+	}
+	return ""
+}
+
+// envFilePath returns the file holding the service's environment, next to
+// the client's config since it can contain the token.
+func envFilePath(name string) (string, error) {
+	dir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".env"), nil
+}
+
+// writeEnvFile writes the DR1LL_* variables currently set to path, readable
+// only by the owner, removing it when there are none.
+func writeEnvFile(path string) error {
+	var env strings.Builder
+	for _, v := range os.Environ() {
+		key, value, _ := strings.Cut(v, "=")
+		if !strings.HasPrefix(key, "DR1LL_") {
+			continue
+		}
+		// The passphrase would sit on disk next to the tokens it protects.
+		if key == config.PassphraseEnv {
+			fmt.Fprintf(os.Stderr, "⚠️  Not writing %s for the service, use %s instead\n", config.PassphraseEnv, config.KeyFileEnv)
+			continue
+		}
+		fmt.Fprintf(&env, "%s=%s\n", key, envQuote(value))
+	}
+
+	if env.Len() == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(env.String()), 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
+// envQuote quotes value for an EnvironmentFile= line when needed.
+func envQuote(value string) string {
+	if !strings.ContainsAny(value, " \t\"'\\#;") {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// unitPath returns where the user unit is installed.
+func unitPath(unit string) (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "systemd", "user", unit), nil
+}
+
+// serviceUnit generates a unit running "dr1ll start" with args from the
+// current directory, so dr1ll.yaml there is used. The DR1LL_* variables are
+// loaded from envPath, which unlike the unit is not world-readable.
+func serviceUnit(args []string, envPath string) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	command := []string{systemdQuote(exe), "start", "-quiet"}
+	for _, arg := range args {
+		command = append(command, systemdQuote(arg))
+	}
+
+	var unit strings.Builder
+	fmt.Fprintln(&unit, "[Unit]")
+	fmt.Fprintln(&unit, "Description=dr1ll tunnel client")
+	fmt.Fprintln(&unit, "Wants=network-online.target")
+	fmt.Fprintln(&unit, "After=network-online.target")
+	fmt.Fprintln(&unit)
+	fmt.Fprintln(&unit, "[Service]")
+	fmt.Fprintln(&unit, "Type=notify")
+	fmt.Fprintln(&unit, "NotifyAccess=main")
+	fmt.Fprintf(&unit, "ExecStart=%s\n", strings.Join(command, " "))
+	fmt.Fprintf(&unit, "WorkingDirectory=%s\n", systemdQuote(dir))
+	if _, err := os.Stat(envPath); err == nil {
+		fmt.Fprintf(&unit, "EnvironmentFile=%s\n", systemdQuote(envPath))
+	}
+	fmt.Fprintf(&unit, "WatchdogSec=%s\n", serviceWatchdog)
+	fmt.Fprintln(&unit, "Restart=on-failure")
+	fmt.Fprintln(&unit, "RestartSec=5s")
+	fmt.Fprintln(&unit)
+	fmt.Fprintln(&unit, "[Install]")
+	fmt.Fprintln(&unit, "WantedBy=default.target")
+	return unit.String(), nil
+}
+
+// systemdQuote quotes s for a unit file command line or assignment when it
+// contains characters systemd would otherwise interpret.
+func systemdQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\$%;") {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `$$`, `%`, `%%`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// systemctl runs systemctl --user with args, passing its output through.
+func systemctl(args ...string) error {
+	cmd := exec.Command("systemctl", append([]string{"--user"}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
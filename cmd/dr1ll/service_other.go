@@ -0,0 +1,19 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runAsService reports false: only Windows starts the client as a service
+// this way.
+func runAsService() bool {
+	return false
+}
+
+func serviceCommand() {
+	fmt.Println("dr1ll service manages systemd user units and is only available on Linux.")
+	os.Exit(1)
+}
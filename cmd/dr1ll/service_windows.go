@@ -0,0 +1,53 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+type DrillService struct {
+	RunFunc func()
+}
+
+func (m *DrillService) Execute(args []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (bool, uint32) {
+	s <- svc.Status{State: svc.StartPending}
+	s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	go m.RunFunc()
+	for c := range r {
+		switch c.Cmd {
+		case svc.Interrogate:
+			s <- c.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			slog.Info("service stopping")
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+// runAsService runs the client under the Windows service manager when it
+// was started by it, returning true once the service stops.
+func runAsService() bool {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		fatal("failed to detect Windows service", "error", err)
+	}
+	if !isService {
+		return false
+	}
+	svc.Run("DrillService", &DrillService{RunFunc: startCommand})
+	return true
+}
+
+func serviceCommand() {
+	fmt.Println("dr1ll service manages systemd user units and is only available on Linux.")
+	fmt.Println("On Windows, register dr1ll with the service manager, e.g.:")
+	fmt.Println(`  sc.exe create DrillService binPath= "C:\path\to\dr1ll.exe start -quiet"`)
+	os.Exit(1)
+}
@@ -1,62 +1,214 @@
 package client
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/M1z23R/dr1ll/internal/httpheader"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
+// Bounds of the backoff between reconnect attempts after the server goes
+// away.
+const (
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = 30 * time.Second
+)
+
+// defaultDrainTimeout is how long shutdown waits for in-flight requests
+// unless SetDrainTimeout says otherwise.
+const defaultDrainTimeout = 30 * time.Second
+
+// pingInterval is how often the client pings the server. A tunnel that has
+// not heard back for a few intervals is no longer reported alive.
+const pingInterval = 10 * time.Second
+
 type Message struct {
-	Type      string            `json:"type"`
-	ID        string            `json:"id,omitempty"`
-	Subdomain string            `json:"subdomain,omitempty"`
-	Method    string            `json:"method,omitempty"`
-	Path      string            `json:"path,omitempty"`
-	Headers   map[string]string `json:"headers,omitempty"`
-	Body      string            `json:"body,omitempty"`
-	Status    int               `json:"status,omitempty"`
-	Error     string            `json:"error,omitempty"`
+	Type      string         `json:"type"`
+	ID        string         `json:"id,omitempty"`
+	Subdomain string         `json:"subdomain,omitempty"`
+	Method    string         `json:"method,omitempty"`
+	Path      string         `json:"path,omitempty"`
+	Headers   httpheader.Map `json:"headers,omitempty"`
+	Body      string         `json:"body,omitempty"`
+	Trailers  httpheader.Map `json:"trailers,omitempty"`
+	Status    int            `json:"status,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	// W3C trace context of the public request, for the upstream span.
+	TraceParent string `json:"traceparent,omitempty"`
+	TraceState  string `json:"tracestate,omitempty"`
 }
 
 type Client struct {
+	name               string // the tunnel's name in dr1ll.yaml, if any
 	conn               *websocket.Conn
-	localPort          int
+	upstream           string // base URL requests are forwarded to
 	serverURL          string
 	token              string
 	requestedSubdomain string
+	basicAuth          string // "user:pass" required from public callers
+	bearerToken        string // bearer token required from public callers
+	oidcLogin          bool
+	oidcDomains        []string
+	oidcGroups         []string
+	allowIPs           []string
+	denyIPs            []string
+	requestHeaders     HeaderRules
+	responseHeaders    HeaderRules
+	transport          http.RoundTripper // nil for the default transport
+	inspect            *InspectOptions
 	done               chan struct{}
 	pendingRequests    map[string]chan Message
-	writeMu            sync.Mutex // Protects WebSocket writes
+	slots              chan struct{} // bounds concurrent upstream requests when set
+	quiet              bool          // suppresses the human-readable banners
+	publicURL          string
+	onActive           func(publicURL string)
+	goingAway          bool // the server announced it is shutting down
+	inFlight           sync.WaitGroup
+	drainTimeout       time.Duration
+	drainAcked         chan struct{} // closed when the server stops routing to us
+	writeMu            sync.Mutex    // Protects WebSocket writes
+	stop               chan struct{} // closed by Stop
+	stopOnce           sync.Once
+	statusMu           sync.Mutex // protects state, startedAt and connectedAt
+	state              string
+	startedAt          time.Time
+	connectedAt        time.Time
+	requests           atomic.Int64
+	errors             atomic.Int64
+	active             atomic.Int64 // requests being forwarded
+	lastSeen           atomic.Int64 // unix nanoseconds of the last message or pong from the server
+	logger             *slog.Logger // nil logs through slog.Default()
 }
 
 func NewClient(serverURL, token string, localPort int) *Client {
 	return &Client{
 		serverURL:       serverURL,
 		token:           token,
-		localPort:       localPort,
+		upstream:        fmt.Sprintf("http://localhost:%d", localPort),
 		done:            make(chan struct{}),
 		pendingRequests: make(map[string]chan Message),
+		drainTimeout:    defaultDrainTimeout,
+		stop:            make(chan struct{}),
+		state:           StateConnecting,
+	}
+}
+
+// SetName sets the name the tunnel is reported under by the control API.
+func (c *Client) SetName(name string) {
+	c.name = name
+}
+
+// SetUpstream forwards requests to upstream instead of the local port given
+// to NewClient. It accepts a port ("3000"), a host and port
+// ("10.0.0.5:8080") or an http(s) URL.
+func (c *Client) SetUpstream(upstream string) error {
+	base, err := parseUpstream(upstream)
+	if err != nil {
+		return err
 	}
+	c.upstream = base
+	return nil
 }
 
 func (c *Client) SetRequestedSubdomain(subdomain string) {
 	c.requestedSubdomain = subdomain
 }
 
-func (c *Client) connect() error {
-	u, err := url.Parse(c.serverURL)
+// SetBasicAuth protects the public URL with HTTP Basic auth. The server
+// enforces it before requests reach the tunnel.
+func (c *Client) SetBasicAuth(username, password string) {
+	c.basicAuth = username + ":" + password
+}
+
+// SetBearerToken protects the public URL with a bearer token. When combined
+// with SetBasicAuth, callers may use either.
+func (c *Client) SetBearerToken(token string) {
+	c.bearerToken = token
+}
+
+// SetOIDCLogin puts the server's OIDC login gateway in front of the public
+// URL. Non-empty domains or groups further restrict who may sign in.
+func (c *Client) SetOIDCLogin(domains, groups []string) {
+	c.oidcLogin = true
+	c.oidcDomains = domains
+	c.oidcGroups = groups
+}
+
+// SetIPRules restricts the public URL to callers matching allow (CIDRs or
+// IPs) and not matching deny. The server evaluates them on every request.
+func (c *Client) SetIPRules(allow, deny []string) {
+	c.allowIPs = allow
+	c.denyIPs = deny
+}
+
+// SetQuiet suppresses the banners printed to stdout; structured logs are
+// unaffected.
+func (c *Client) SetQuiet(quiet bool) {
+	c.quiet = quiet
+}
+
+// SetLogger sets the logger for the client's structured logs, which
+// otherwise go to slog.Default().
+func (c *Client) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+func (c *Client) log() *slog.Logger {
+	if c.logger != nil {
+		return c.logger
+	}
+	return slog.Default()
+}
+
+// banner prints a human-readable status line unless the client is quiet.
+func (c *Client) banner(format string, a ...any) {
+	if !c.quiet {
+		fmt.Printf(format, a...)
+	}
+}
+
+// SetMaxConcurrency caps how many requests are forwarded to the local
+// service at once. Excess requests wait in line; n <= 0 means no limit.
+func (c *Client) SetMaxConcurrency(n int) {
+	if n <= 0 {
+		c.slots = nil
+		return
+	}
+	c.slots = make(chan struct{}, n)
+}
+
+// SetOnActive registers fn to be called each time the server assigns the
+// tunnel its public URL, including after reconnecting.
+func (c *Client) SetOnActive(fn func(publicURL string)) {
+	c.onActive = fn
+}
+
+// SetDrainTimeout sets how long shutdown waits for requests already being
+// forwarded to finish before closing the tunnel.
+func (c *Client) SetDrainTimeout(d time.Duration) {
+	c.drainTimeout = d
+}
+
+// wsEndpoint returns the WebSocket URL of the tunnel server at serverURL.
+func wsEndpoint(serverURL string) (string, error) {
+	u, err := url.Parse(serverURL)
 	if err != nil {
-		return fmt.Errorf("invalid server URL: %v", err)
+		return "", fmt.Errorf("invalid server URL: %v", err)
 	}
 
 	scheme := "ws"
@@ -64,14 +216,46 @@ func (c *Client) connect() error {
 		scheme = "wss"
 	}
 
-	wsURL := fmt.Sprintf("%s://%s/ws", scheme, u.Host)
-	
+	return fmt.Sprintf("%s://%s/ws", scheme, u.Host), nil
+}
+
+func (c *Client) connect() error {
+	wsURL, err := wsEndpoint(c.serverURL)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
 	if c.requestedSubdomain != "" {
-		wsURL += fmt.Sprintf("?subdomain=%s", url.QueryEscape(c.requestedSubdomain))
+		query.Set("subdomain", c.requestedSubdomain)
+	}
+	if c.oidcLogin {
+		query.Set("login", "oidc")
+		if len(c.oidcDomains) > 0 {
+			query.Set("login_domains", strings.Join(c.oidcDomains, ","))
+		}
+		if len(c.oidcGroups) > 0 {
+			query.Set("login_groups", strings.Join(c.oidcGroups, ","))
+		}
+	}
+	if len(c.allowIPs) > 0 {
+		query.Set("allow_ips", strings.Join(c.allowIPs, ","))
+	}
+	if len(c.denyIPs) > 0 {
+		query.Set("deny_ips", strings.Join(c.denyIPs, ","))
+	}
+	if len(query) > 0 {
+		wsURL += "?" + query.Encode()
 	}
 
 	headers := http.Header{}
 	headers.Set("Authorization", "Bearer "+c.token)
+	if c.basicAuth != "" {
+		headers.Set("X-Dr1ll-Auth-Basic", base64.StdEncoding.EncodeToString([]byte(c.basicAuth)))
+	}
+	if c.bearerToken != "" {
+		headers.Set("X-Dr1ll-Auth-Bearer", c.bearerToken)
+	}
 
 	conn, _, err := websocket.DefaultDialer.Dial(wsURL, headers)
 	if err != nil {
@@ -81,31 +265,107 @@ func (c *Client) connect() error {
 		return fmt.Errorf("failed to connect to server: %v", err)
 	}
 
+	// Requests of the previous connection may still be answering on it, so
+	// the swap happens under writeMu.
+	c.writeMu.Lock()
 	c.conn = conn
+	c.drainAcked = make(chan struct{})
+	c.writeMu.Unlock()
 	return nil
 }
 
-func (c *Client) handleMessages() {
-	defer c.conn.Close()
+// tunnelURL returns the public URL the server assigned, empty until the
+// first connection is active.
+func (c *Client) tunnelURL() string {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	return c.publicURL
+}
+
+// keepAlive pings the server over conn until done is closed, so a
+// connection that silently died stops counting as alive.
+func (c *Client) keepAlive(conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingInterval)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) seen() {
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+// Alive reports whether the tunnel is connected and has heard from the
+// server within the last few pings, for health checks such as the systemd
+// watchdog.
+func (c *Client) Alive() bool {
+	c.statusMu.Lock()
+	state := c.state
+	c.statusMu.Unlock()
+	if state != StateActive && state != StateDraining {
+		return false
+	}
+	return time.Since(time.Unix(0, c.lastSeen.Load())) < 3*pingInterval
+}
 
+func (c *Client) handleMessages() {
+	conn := c.conn
+	defer conn.Close()
+
+	c.seen()
+	conn.SetPongHandler(func(string) error {
+		c.seen()
+		return nil
+	})
+	go c.keepAlive(conn, c.done)
+
+	// A repeated drain_ack must not close drainAcked twice.
+	var drainAckOnce sync.Once
 	for {
 		var msg Message
-		if err := c.conn.ReadJSON(&msg); err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+		if err := conn.ReadJSON(&msg); err != nil {
+			if websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				c.goingAway = true
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseAbnormalClosure) {
+				c.log().Warn("WebSocket error", "tunnel", c.tunnelURL(), "error", err)
 			}
 			close(c.done)
 			return
 		}
+		c.seen()
 
 		switch msg.Type {
 		case "subdomain_assigned":
-			fmt.Printf("🚀 Tunnel active! Your URL is: %s\n", msg.Subdomain)
-			fmt.Printf("💡 Forwarding requests to localhost:%d\n", c.localPort)
-			fmt.Println("📝 Press Ctrl+C to stop the tunnel")
+			c.statusMu.Lock()
+			c.publicURL = msg.Subdomain
+			c.state, c.connectedAt = StateActive, time.Now()
+			c.statusMu.Unlock()
+			c.log().Info("tunnel active", "tunnel", msg.Subdomain, "upstream", c.upstream)
+			c.banner("🚀 Tunnel active! Your URL is: %s\n", msg.Subdomain)
+			c.banner("💡 Forwarding requests to %s\n", c.upstream)
+			c.banner("📝 Press Ctrl+C to stop the tunnel\n")
+			if c.onActive != nil {
+				c.onActive(msg.Subdomain)
+			}
 
 		case "http_request":
-			go c.forwardRequest(msg)
+			c.inFlight.Add(1)
+			go func(msg Message) {
+				defer c.inFlight.Done()
+				if c.slots != nil {
+					c.slots <- struct{}{}
+					defer func() { <-c.slots }()
+				}
+				c.forwardRequest(conn, msg)
+			}(msg)
 
 		case "http_response":
 			if ch, ok := c.pendingRequests[msg.ID]; ok {
@@ -113,128 +373,285 @@ func (c *Client) handleMessages() {
 				delete(c.pendingRequests, msg.ID)
 			}
 
+		case "drain_ack":
+			drainAckOnce.Do(func() { close(c.drainAcked) })
+
+		case "server_shutdown":
+			c.goingAway = true
+			c.log().Info("server is shutting down, will reconnect", "tunnel", c.tunnelURL())
+
 		case "error":
-			log.Printf("❌ Server error: %s", msg.Error)
+			c.log().Error("server error", "tunnel", c.tunnelURL(), "error", msg.Error)
 
 		default:
-			log.Printf("Unknown message type: %s", msg.Type)
+			c.log().Warn("unknown message type", "type", msg.Type)
 		}
 	}
 }
 
-func (c *Client) forwardRequest(msg Message) {
-	localURL := fmt.Sprintf("http://localhost:%d%s", c.localPort, msg.Path)
+// forwardRequest serves msg from the upstream and answers on conn, the
+// connection the request arrived on.
+func (c *Client) forwardRequest(conn *websocket.Conn, msg Message) {
+	start := time.Now()
+	localURL := c.upstream + msg.Path
+
+	c.requests.Add(1)
+	c.active.Add(1)
+	defer c.active.Add(-1)
+
+	ctx, span := startUpstreamSpan(msg, localURL)
+	defer span.End()
 
 	var bodyReader io.Reader
 	if msg.Body != "" {
 		bodyReader = strings.NewReader(msg.Body)
 	}
 
-	req, err := http.NewRequest(msg.Method, localURL, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, msg.Method, localURL, bodyReader)
 	if err != nil {
-		c.sendErrorResponse(msg.ID, fmt.Sprintf("Failed to create request: %v", err))
+		failSpan(span, err)
+		c.sendErrorResponse(conn, msg.ID, fmt.Sprintf("Failed to create request: %v", err))
 		return
 	}
 
-	for name, value := range msg.Headers {
-		if name != "Host" {
-			req.Header.Set(name, value)
-		}
+	req.Header = httpheader.FromMap(msg.Headers)
+	httpheader.RemoveHopByHop(req.Header)
+	req.Header.Del("Host")
+	req.Header.Del("Content-Length")
+	c.requestHeaders.apply(req.Header)
+	injectUpstreamTrace(ctx, req.Header)
+	c.inspectRequest(msg, req.Header)
+
+	// Trailers can only follow a chunked body.
+	if len(msg.Trailers) > 0 && bodyReader != nil {
+		req.Trailer = httpheader.FromMap(msg.Trailers)
+		req.ContentLength = -1
 	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
+	client := &http.Client{Timeout: 30 * time.Second, Transport: c.transport}
 	resp, err := client.Do(req)
 	if err != nil {
-		c.sendErrorResponse(msg.ID, fmt.Sprintf("Request failed: %v", err))
+		failSpan(span, err)
+		c.sendErrorResponse(conn, msg.ID, fmt.Sprintf("Request failed: %v", err))
 		return
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		c.sendErrorResponse(msg.ID, fmt.Sprintf("Failed to read response: %v", err))
+		failSpan(span, err)
+		c.sendErrorResponse(conn, msg.ID, fmt.Sprintf("Failed to read response: %v", err))
 		return
 	}
 
-	respHeaders := make(map[string]string)
-	for name, values := range resp.Header {
-		if len(values) > 0 {
-			respHeaders[name] = values[0]
-		}
+	httpheader.RemoveHopByHop(resp.Header)
+	c.responseHeaders.apply(resp.Header)
+	c.inspectResponse(msg.ID, resp.StatusCode, resp.Header, respBody)
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
 	}
 
 	response := Message{
-		Type:    "http_response",
-		ID:      msg.ID,
-		Status:  resp.StatusCode,
-		Headers: respHeaders,
-		Body:    string(respBody),
+		Type:     "http_response",
+		ID:       msg.ID,
+		Status:   resp.StatusCode,
+		Headers:  httpheader.ToMap(resp.Header),
+		Body:     string(respBody),
+		Trailers: httpheader.ToMap(resp.Trailer),
 	}
 
 	c.writeMu.Lock()
-	err = c.conn.WriteJSON(response)
+	err = conn.WriteJSON(response)
 	c.writeMu.Unlock()
 
 	if err != nil {
-		log.Printf("Failed to send response: %v", err)
+		c.log().Error("failed to send response", "tunnel", c.tunnelURL(), "request_id", msg.ID, "error", err)
 	}
 
-	log.Printf("✅ %s %s -> %d", msg.Method, msg.Path, resp.StatusCode)
+	c.log().Info("request forwarded",
+		"tunnel", c.tunnelURL(), "request_id", msg.ID, "user", msg.Headers.Get("X-Forwarded-User"),
+		"method", msg.Method, "path", msg.Path, "status", resp.StatusCode, "duration", time.Since(start))
 }
 
-func (c *Client) sendErrorResponse(requestID, errorMsg string) {
+func (c *Client) sendErrorResponse(conn *websocket.Conn, requestID, errorMsg string) {
+	c.errors.Add(1)
 	response := Message{
 		Type:   "http_response",
 		ID:     requestID,
 		Status: 500,
-		Headers: map[string]string{
-			"Content-Type": "application/json",
+		Headers: httpheader.Map{
+			"Content-Type": {"application/json"},
 		},
 		Body: fmt.Sprintf(`{"error": "%s"}`, errorMsg),
 	}
 
 	c.writeMu.Lock()
-	err := c.conn.WriteJSON(response)
+	err := conn.WriteJSON(response)
 	c.writeMu.Unlock()
 
 	if err != nil {
-		log.Printf("Failed to send error response: %v", err)
+		c.log().Error("failed to send error response", "tunnel", c.tunnelURL(), "request_id", requestID, "error", err)
 	}
 
-	log.Printf("❌ Request %s failed: %s", requestID, errorMsg)
+	c.log().Warn("request failed", "tunnel", c.tunnelURL(), "request_id", requestID, "error", errorMsg)
 }
 
+// Run runs the tunnel until the connection closes, Stop is called or the
+// process is interrupted, draining in-flight requests before returning.
 func (c *Client) Run() error {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	return c.run(interrupt)
+}
+
+// RunContext runs the tunnel like Run, but stops it when ctx is done rather
+// than on interrupt signals, for programs embedding the client.
+func (c *Client) RunContext(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, c.Stop)
+	defer stop()
+
+	return c.run(nil)
+}
+
+// run runs the tunnel; interrupt may be nil.
+func (c *Client) run(interrupt chan os.Signal) error {
+	c.setState(StateConnecting)
+	c.statusMu.Lock()
+	c.startedAt = time.Now()
+	c.statusMu.Unlock()
+	defer c.setState(StateClosed)
+
 	if err := c.connect(); err != nil {
 		return err
 	}
 
-	fmt.Println("🔌 Connecting to tunnel server...")
+	c.banner("🔌 Connecting to tunnel server...\n")
 
-	go c.handleMessages()
+	for {
+		go c.handleMessages()
 
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		select {
+		case <-c.done:
+			if !c.goingAway {
+				c.log().Info("connection closed", "tunnel", c.tunnelURL())
+				return nil
+			}
+			if !c.reconnect(interrupt) {
+				return nil
+			}
+
+		case <-interrupt:
+			c.log().Info("interrupt received, draining", "tunnel", c.tunnelURL(), "timeout", c.drainTimeout)
+			c.banner("⏳ Finishing in-flight requests, press Ctrl+C again to quit now...\n")
+			c.shutdown(interrupt)
+			return nil
+
+		case <-c.stop:
+			c.log().Info("stop requested, draining", "tunnel", c.tunnelURL(), "timeout", c.drainTimeout)
+			c.shutdown(interrupt)
+			return nil
+		}
+	}
+}
+
+// Stop drains and closes the tunnel as if interrupted, making Run return.
+func (c *Client) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// shutdown drains the tunnel and closes the connection.
+func (c *Client) shutdown(interrupt <-chan os.Signal) {
+	c.setState(StateDraining)
+	c.drain(interrupt)
+
+	c.writeMu.Lock()
+	err := c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	c.writeMu.Unlock()
+
+	if err != nil {
+		c.log().Warn("failed to send close message", "error", err)
+	}
+
+	select {
+	case <-c.done:
+	case <-time.After(time.Second):
+	}
+}
 
+// drain asks the server to stop routing requests to this tunnel, then waits
+// for those already routed to finish, up to the drain timeout or until
+// interrupted again.
+func (c *Client) drain(interrupt <-chan os.Signal) {
+	c.writeMu.Lock()
+	err := c.conn.WriteJSON(Message{Type: "drain"})
+	c.writeMu.Unlock()
+	if err != nil {
+		c.log().Warn("failed to send drain message", "error", err)
+		return
+	}
+
+	timeout := time.After(c.drainTimeout)
+
+	// Requests routed before the server saw the drain arrive ahead of its
+	// acknowledgement, so wait for it before counting what is in flight.
 	select {
+	case <-c.drainAcked:
 	case <-c.done:
-		log.Println("Connection closed")
+		return
+	case <-timeout:
+		c.log().Warn("drain timeout reached before the server acknowledged", "tunnel", c.tunnelURL())
+		return
 	case <-interrupt:
-		log.Println("Interrupt received, closing connection...")
+		return
+	}
 
-		c.writeMu.Lock()
-		err := c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-		c.writeMu.Unlock()
+	finished := make(chan struct{})
+	go func() {
+		c.inFlight.Wait()
+		close(finished)
+	}()
 
-		if err != nil {
-			log.Printf("Error sending close message: %v", err)
-		}
+	select {
+	case <-finished:
+		c.log().Info("in-flight requests finished", "tunnel", c.tunnelURL())
+	case <-timeout:
+		c.log().Warn("drain timeout reached, abandoning in-flight requests", "tunnel", c.tunnelURL())
+	case <-interrupt:
+		c.log().Warn("interrupted again, abandoning in-flight requests", "tunnel", c.tunnelURL())
+	}
+}
+
+// reconnect re-establishes the tunnel after the server went away, asking for
+// the same subdomain and backing off between attempts. It returns false if
+// interrupted first.
+func (c *Client) reconnect(interrupt <-chan os.Signal) bool {
+	if subdomain, _, ok := strings.Cut(c.tunnelURL(), "."); ok {
+		c.requestedSubdomain = subdomain
+	}
+	c.banner("🔄 Server is going away, reconnecting...\n")
+	c.setState(StateReconnecting)
 
+	delay := reconnectMinDelay
+	for {
 		select {
-		case <-c.done:
-		case <-time.After(time.Second):
+		case <-interrupt:
+			return false
+		case <-c.stop:
+			return false
+		case <-time.After(delay):
 		}
-	}
 
-	return nil
-}
\ No newline at end of file
+		if err := c.connect(); err != nil {
+			c.log().Warn("reconnect failed", "tunnel", c.tunnelURL(), "retry_in", delay, "error", err)
+			delay = min(delay*2, reconnectMaxDelay)
+			continue
+		}
+
+		c.done = make(chan struct{})
+		c.goingAway = false
+		return true
+	}
+}
@@ -0,0 +1,227 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Tunnel states reported by the control API.
+const (
+	StateConnecting   = "connecting"
+	StateActive       = "active"
+	StateReconnecting = "reconnecting"
+	StateDraining     = "draining"
+	StateClosed       = "closed"
+)
+
+// ErrTunnelNotFound is returned by ControlStop when the process has no
+// tunnel by that name.
+var ErrTunnelNotFound = errors.New("tunnel not found")
+
+// Status describes a tunnel for the control API.
+type Status struct {
+	Name        string    `json:"name,omitempty"`
+	PublicURL   string    `json:"public_url,omitempty"`
+	Upstream    string    `json:"upstream"`
+	Server      string    `json:"server"`
+	State       string    `json:"state"`
+	PID         int       `json:"pid"`
+	StartedAt   time.Time `json:"started_at"`
+	ConnectedAt time.Time `json:"connected_at"` // zero until the first connection
+	Requests    int64     `json:"requests"`
+	InFlight    int64     `json:"in_flight"`
+	Errors      int64     `json:"errors"`
+}
+
+// Status reports the tunnel's public URL, connection state and counters.
+func (c *Client) Status() Status {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	return Status{
+		Name:        c.name,
+		PublicURL:   c.publicURL,
+		Upstream:    c.upstream,
+		Server:      c.serverURL,
+		State:       c.state,
+		PID:         os.Getpid(),
+		StartedAt:   c.startedAt,
+		ConnectedAt: c.connectedAt,
+		Requests:    c.requests.Load(),
+		InFlight:    c.active.Load(),
+		Errors:      c.errors.Load(),
+	}
+}
+
+func (c *Client) setState(state string) {
+	c.statusMu.Lock()
+	c.state = state
+	c.statusMu.Unlock()
+}
+
+// matches reports whether name identifies the tunnel: its name, its
+// subdomain or its public URL.
+func (c *Client) matches(name string) bool {
+	status := c.Status()
+	subdomain, _, _ := strings.Cut(status.PublicURL, ".")
+	return name != "" && (name == status.Name || name == subdomain || name == status.PublicURL)
+}
+
+// Control serves the local control API for the tunnels of this process on
+// a Unix socket: GET /tunnels lists them and POST /tunnels/{name}/stop
+// stops one.
+type Control struct {
+	path     string
+	listener net.Listener
+	mu       sync.Mutex
+	clients  []*Client
+}
+
+// ListenControl creates the control socket at path, replacing one left
+// behind by a process that no longer runs. Only the owner can connect.
+func ListenControl(path string) (*Control, error) {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	switch {
+	case err == nil:
+		conn.Close()
+		return nil, fmt.Errorf("control socket %s is in use", path)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		// Dialing a regular file is refused too; only remove sockets.
+		if info, err := os.Lstat(path); err != nil || info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &Control{path: path, listener: listener}, nil
+}
+
+// Add makes c visible through the control API.
+func (ctl *Control) Add(c *Client) {
+	ctl.mu.Lock()
+	ctl.clients = append(ctl.clients, c)
+	ctl.mu.Unlock()
+}
+
+// Serve handles control requests until Close is called.
+func (ctl *Control) Serve() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tunnels", ctl.handleList)
+	mux.HandleFunc("POST /tunnels/{name}/stop", ctl.handleStop)
+
+	err := http.Serve(ctl.listener, mux)
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close stops serving and removes the socket.
+func (ctl *Control) Close() error {
+	err := ctl.listener.Close()
+	os.Remove(ctl.path)
+	return err
+}
+
+func (ctl *Control) handleList(w http.ResponseWriter, r *http.Request) {
+	ctl.mu.Lock()
+	statuses := make([]Status, 0, len(ctl.clients))
+	for _, c := range ctl.clients {
+		statuses = append(statuses, c.Status())
+	}
+	ctl.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+func (ctl *Control) handleStop(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	for _, c := range ctl.clients {
+		if c.matches(name) {
+			c.Stop()
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+	}
+	http.Error(w, ErrTunnelNotFound.Error(), http.StatusNotFound)
+}
+
+// controlClient returns an HTTP client talking to the control socket at
+// path.
+func controlClient(path string) *http.Client {
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+// ControlStatus asks the process serving the control socket at path for
+// the status of its tunnels.
+func ControlStatus(path string) ([]Status, error) {
+	resp, err := controlClient(path).Get("http://dr1ll/tunnels")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var statuses []Status
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// ControlStop asks the process serving the control socket at path to stop
+// the tunnel identified by name, its subdomain or its public URL. The
+// tunnel drains in the background.
+func ControlStop(path, name string) error {
+	resp, err := controlClient(path).Post("http://dr1ll/tunnels/"+url.PathEscape(name)+"/stop", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		return nil
+	case http.StatusNotFound:
+		return ErrTunnelNotFound
+	default:
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+}
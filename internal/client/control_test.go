@@ -0,0 +1,159 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// socketPath returns a control socket path short enough for the Unix
+// socket limit.
+func socketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "ctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "c.sock")
+}
+
+func startControl(t *testing.T, path string, clients ...*Client) *Control {
+	t.Helper()
+	ctl, err := ListenControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clients {
+		ctl.Add(c)
+	}
+	go ctl.Serve()
+	t.Cleanup(func() { ctl.Close() })
+	return ctl
+}
+
+func TestListenControl(t *testing.T) {
+	t.Run("socket is private", func(t *testing.T) {
+		path := socketPath(t)
+		startControl(t, path)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("socket mode = %04o, want 0600", perm)
+		}
+	})
+
+	t.Run("live socket is kept", func(t *testing.T) {
+		path := socketPath(t)
+		startControl(t, path)
+		if _, err := ListenControl(path); err == nil {
+			t.Fatal("took over the socket of a running process")
+		}
+		if _, err := ControlStatus(path); err != nil {
+			t.Errorf("running process lost its socket: %v", err)
+		}
+	})
+
+	t.Run("stale socket is replaced", func(t *testing.T) {
+		path := socketPath(t)
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.(*net.UnixListener).SetUnlinkOnClose(false)
+		l.Close()
+
+		startControl(t, path)
+		if _, err := ControlStatus(path); err != nil {
+			t.Errorf("control socket not served: %v", err)
+		}
+	})
+
+	t.Run("regular file is kept", func(t *testing.T) {
+		path := socketPath(t)
+		if err := os.WriteFile(path, []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ListenControl(path); err == nil {
+			t.Fatal("listened over a regular file")
+		}
+		if data, _ := os.ReadFile(path); string(data) != "data" {
+			t.Error("regular file was removed")
+		}
+	})
+}
+
+func TestControlAPI(t *testing.T) {
+	web := NewClient("https://tunnel.example.com", "token", 3000)
+	web.SetName("web")
+	web.publicURL = "web.tunnel.example.com"
+	api := NewClient("https://tunnel.example.com", "token", 4000)
+	api.publicURL = "api-x1.tunnel.example.com"
+
+	path := socketPath(t)
+	startControl(t, path, web, api)
+
+	statuses, err := ControlStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("got %d tunnels, want 2", len(statuses))
+	}
+	if s := statuses[0]; s.Name != "web" || s.PublicURL != "web.tunnel.example.com" ||
+		s.Upstream != "http://localhost:3000" || s.State != StateConnecting || s.PID != os.Getpid() {
+		t.Errorf("status = %+v", s)
+	}
+
+	tests := []struct {
+		name    string
+		stopped *Client
+		wantErr error
+	}{
+		{name: "web", stopped: web},
+		{name: "api-x1", stopped: api},
+		{name: "api-x1.tunnel.example.com", stopped: api},
+		{name: "missing", wantErr: ErrTunnelNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ControlStop(path, tt.name)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ControlStop error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.stopped != nil {
+				select {
+				case <-tt.stopped.stop:
+				default:
+					t.Error("tunnel was not stopped")
+				}
+			}
+		})
+	}
+}
+
+func TestClientMatches(t *testing.T) {
+	c := NewClient("https://tunnel.example.com", "token", 3000)
+	c.SetName("web")
+	c.publicURL = "abc.tunnel.example.com"
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"web", true},
+		{"abc", true},
+		{"abc.tunnel.example.com", true},
+		{"", false},
+		{"tunnel", false},
+	}
+	for _, tt := range tests {
+		if got := c.matches(tt.name); got != tt.want {
+			t.Errorf("matches(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Probe checks that a tunnel could be opened on the server at serverURL
+// with token. It performs the WebSocket handshake, which the server closes
+// straight away without assigning a subdomain, and explains any failure.
+func Probe(ctx context.Context, serverURL, token string) error {
+	wsURL, err := wsEndpoint(serverURL)
+	if err != nil {
+		return err
+	}
+
+	headers := http.Header{}
+	headers.Set("Authorization", "Bearer "+token)
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, wsURL+"?probe=1", headers)
+	if err != nil {
+		if resp == nil {
+			return fmt.Errorf("failed to connect to %s: %w", wsURL, err)
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		reason := strings.TrimSpace(string(body))
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			return fmt.Errorf("the server rejected the token")
+		case http.StatusForbidden:
+			return fmt.Errorf("the server refused a tunnel for this token: %s", reason)
+		case http.StatusServiceUnavailable:
+			return fmt.Errorf("the server is shutting down, try again shortly")
+		case http.StatusNotFound, http.StatusBadRequest:
+			return fmt.Errorf("%s did not answer like a dr1ll server (HTTP %d), check the server URL", wsURL, resp.StatusCode)
+		default:
+			return fmt.Errorf("handshake failed with HTTP %d: %s", resp.StatusCode, reason)
+		}
+	}
+	defer conn.Close()
+
+	// Servers predating probes open a real tunnel, so close it politely.
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	return nil
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// handlerUpstream is the upstream reported for tunnels served by an
+// http.Handler.
+const handlerUpstream = "http://handler"
+
+// defaultInspectBody is how much of each body inspection logs unless
+// InspectOptions says otherwise.
+const defaultInspectBody = 1024
+
+// HeaderRules rewrites the headers of requests or responses passing
+// through the tunnel. Remove is applied before Set.
+type HeaderRules struct {
+	Set    map[string]string
+	Remove []string
+}
+
+func (r HeaderRules) apply(header http.Header) {
+	for _, name := range r.Remove {
+		header.Del(name)
+	}
+	for name, value := range r.Set {
+		header.Set(name, value)
+	}
+}
+
+// SetHeaderRules rewrites the headers of requests before they are forwarded
+// upstream and of responses before they are sent back.
+func (c *Client) SetHeaderRules(request, response HeaderRules) {
+	c.requestHeaders = request
+	c.responseHeaders = response
+}
+
+// InspectOptions controls request inspection. MaxBodySize caps how many
+// bytes of each body are logged; zero means the default and a negative
+// value omits bodies.
+type InspectOptions struct {
+	MaxBodySize int
+}
+
+// SetInspect logs the headers and the start of the body of every request
+// and response passing through the tunnel.
+func (c *Client) SetInspect(opts InspectOptions) {
+	if opts.MaxBodySize == 0 {
+		opts.MaxBodySize = defaultInspectBody
+	}
+	c.inspect = &opts
+}
+
+func (c *Client) inspectRequest(msg Message, header http.Header) {
+	if c.inspect == nil {
+		return
+	}
+	c.log().Info("inspect request",
+		"tunnel", c.tunnelURL(), "request_id", msg.ID, "method", msg.Method, "path", msg.Path,
+		"headers", header, "body", c.inspectBody([]byte(msg.Body)))
+}
+
+func (c *Client) inspectResponse(requestID string, status int, header http.Header, body []byte) {
+	if c.inspect == nil {
+		return
+	}
+	c.log().Info("inspect response",
+		"tunnel", c.tunnelURL(), "request_id", requestID, "status", status,
+		"headers", header, "body", c.inspectBody(body))
+}
+
+func (c *Client) inspectBody(body []byte) string {
+	if c.inspect.MaxBodySize < 0 {
+		return ""
+	}
+	if len(body) > c.inspect.MaxBodySize {
+		return fmt.Sprintf("%s... (%d bytes)", body[:c.inspect.MaxBodySize], len(body))
+	}
+	return string(body)
+}
+
+// SetHandler serves tunneled requests with handler in-process instead of
+// forwarding them to an upstream.
+func (c *Client) SetHandler(handler http.Handler) {
+	c.upstream = handlerUpstream
+	c.transport = handlerTransport{handler}
+}
+
+// handlerTransport is a RoundTripper that answers requests with an
+// http.Handler, presenting them as they arrived at the tunnel server.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	r := req.Clone(req.Context())
+	r.RequestURI = r.URL.RequestURI()
+	// Handlers may read the body of any request, as a server's is never nil.
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		r.Host = host
+	}
+	if chain := r.Header.Get("X-Forwarded-For"); chain != "" {
+		ip, _, _ := strings.Cut(chain, ",")
+		r.RemoteAddr = net.JoinHostPort(strings.TrimSpace(ip), "0")
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("handler panicked: %v", v)
+		}
+	}()
+
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, r)
+	return rec.Result(), nil
+}
+
+// parseUpstream turns a port, host:port or URL into the base URL requests
+// are forwarded to.
+func parseUpstream(upstream string) (string, error) {
+	if port, err := strconv.Atoi(upstream); err == nil {
+		return fmt.Sprintf("http://localhost:%d", port), nil
+	}
+	if !strings.Contains(upstream, "://") {
+		upstream = "http://" + upstream
+	}
+
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return "", fmt.Errorf("invalid upstream: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid upstream %q: scheme must be http or https", upstream)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid upstream %q: missing host", upstream)
+	}
+	return u.Scheme + "://" + u.Host + strings.TrimSuffix(u.Path, "/"), nil
+}
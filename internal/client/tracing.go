@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/M1z23R/dr1ll/internal/client")
+
+// startUpstreamSpan opens the client span for forwarding msg to the local
+// app, as a child of the server span carried in the message.
+func startUpstreamSpan(msg Message, localURL string) (context.Context, trace.Span) {
+	carrier := propagation.MapCarrier{}
+	if msg.TraceParent != "" {
+		carrier.Set("traceparent", msg.TraceParent)
+	}
+	if msg.TraceState != "" {
+		carrier.Set("tracestate", msg.TraceState)
+	}
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), carrier)
+
+	return tracer.Start(ctx, msg.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", msg.Method),
+			attribute.String("url.full", localURL),
+			attribute.String("dr1ll.request_id", msg.ID),
+		),
+	)
+}
+
+// injectUpstreamTrace replaces any incoming trace headers with the upstream
+// span, so the local app continues the trace from here.
+func injectUpstreamTrace(ctx context.Context, header http.Header) {
+	header.Del("Traceparent")
+	header.Del("Tracestate")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// failSpan marks the upstream span as failed.
+func failSpan(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
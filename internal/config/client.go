@@ -0,0 +1,202 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// DefaultProfile is the profile used when none has been selected.
+const DefaultProfile = "default"
+
+// Profile is a named tunnel server and the token used to connect to it.
+type Profile struct {
+	Name         string `json:"-"`
+	TunnelServer string `json:"tunnel_server"`
+	Token        string `json:"token"`
+}
+
+// ClientConfig is the client's configuration, kept in client.json.
+type ClientConfig struct {
+	CurrentProfile string              `json:"current_profile,omitempty"`
+	Profiles       map[string]*Profile `json:"profiles"`
+
+	encrypted bool // whether Save encrypts the tokens
+}
+
+// LoadClient reads and validates the client config. It returns ErrNoConfig
+// if none has been written yet.
+func LoadClient() (*ClientConfig, error) {
+	config, err := readClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		configPath, _ := GetClientConfigPath()
+		return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+	}
+
+	return config, nil
+}
+
+// readClient reads the client config without validating it.
+func readClient() (*ClientConfig, error) {
+	if err := migrateLegacyConfig(); err != nil {
+		return nil, err
+	}
+
+	configPath, err := GetClientConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	var config ClientConfig
+	if err := readJSON(configPath, &config); err != nil {
+		return nil, err
+	}
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]*Profile)
+	}
+	if config.encrypted, err = decryptSecrets(config.secrets()); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", configPath, err)
+	}
+
+	return &config, nil
+}
+
+// Save validates and writes the client config.
+func (c *ClientConfig) Save() error {
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	configPath, err := GetClientConfigPath()
+	if err != nil {
+		return err
+	}
+
+	if !c.encrypted {
+		return writeJSON(configPath, c)
+	}
+	return withEncryptedSecrets(c.secrets(), func() error {
+		return writeJSON(configPath, c)
+	})
+}
+
+// Encrypted reports whether the config file stores its tokens encrypted.
+func (c *ClientConfig) Encrypted() bool {
+	return c.encrypted
+}
+
+// secrets returns pointers to the profiles' tokens.
+func (c *ClientConfig) secrets() []*string {
+	secrets := make([]*string, 0, len(c.Profiles))
+	for _, profile := range c.Profiles {
+		secrets = append(secrets, &profile.Token)
+	}
+	return secrets
+}
+
+// resolve returns the profile name to use for name, which may be empty to
+// mean the current profile.
+func (c *ClientConfig) resolve(name string) string {
+	if name != "" {
+		return name
+	}
+	if c.CurrentProfile != "" {
+		return c.CurrentProfile
+	}
+	return DefaultProfile
+}
+
+// Profile returns the named profile, or the current one if name is empty.
+func (c *ClientConfig) Profile(name string) (*Profile, error) {
+	name = c.resolve(name)
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %q not found", name)
+	}
+
+	profile.Name = name
+	return profile, nil
+}
+
+// ProfileNames returns the names of all profiles, sorted.
+func (c *ClientConfig) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// updateProfile applies update to the named profile, or the current one if
+// name is empty, creating the profile and the config file as needed. The
+// first profile created becomes the current one.
+func updateProfile(name string, update func(*Profile)) error {
+	config, err := readClient()
+	if errors.Is(err, ErrNoConfig) {
+		config, err = &ClientConfig{Profiles: make(map[string]*Profile)}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	name = config.resolve(name)
+	profile, ok := config.Profiles[name]
+	if !ok {
+		profile = &Profile{}
+		config.Profiles[name] = profile
+	}
+	if config.CurrentProfile == "" {
+		config.CurrentProfile = name
+	}
+
+	update(profile)
+	return config.Save()
+}
+
+// SetServer sets the tunnel server URL of a profile; an empty profile means
+// the current one.
+func SetServer(profile, server string) error {
+	return updateProfile(profile, func(p *Profile) {
+		p.TunnelServer = server
+	})
+}
+
+// SetToken sets the authentication token of a profile; an empty profile
+// means the current one.
+func SetToken(profile, token string) error {
+	return updateProfile(profile, func(p *Profile) {
+		p.Token = token
+	})
+}
+
+// SetClientEncryption turns encryption of the profiles' tokens on or off,
+// rewriting the file.
+func SetClientEncryption(enabled bool) error {
+	config, err := readClient()
+	if err != nil {
+		return err
+	}
+
+	config.encrypted = enabled
+	return config.Save()
+}
+
+// UseProfile makes an existing profile the current one.
+func UseProfile(name string) error {
+	config, err := readClient()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := config.Profiles[name]; !ok {
+		return fmt.Errorf("profile %q not found", name)
+	}
+
+	config.CurrentProfile = name
+	return config.Save()
+}
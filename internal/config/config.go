@@ -2,19 +2,85 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 )
 
-type Config struct {
-	TunnelServer string `json:"tunnel_server"`
-	Token        string `json:"token"`
-	
-	// Server configuration
+// ErrNoConfig is returned when a configuration file has not been written yet.
+var ErrNoConfig = errors.New("no configuration file found")
+
+// ServerConfig is the server's configuration, kept in server.json.
+type ServerConfig struct {
 	ServerPort   string `json:"server_port,omitempty"`
 	ServerDomain string `json:"server_domain,omitempty"`
 	ServerToken  string `json:"server_token,omitempty"`
+
+	ServerAdminAddr      string             `json:"server_admin_addr,omitempty"`
+	ServerAdminToken     string             `json:"server_admin_token,omitempty"`
+	ServerTrustedProxies []string           `json:"server_trusted_proxies,omitempty"`
+	ServerAllowIPs       []string           `json:"server_allow_ips,omitempty"`
+	ServerDenyIPs        []string           `json:"server_deny_ips,omitempty"`
+	ServerOIDC           *OIDCSettings      `json:"server_oidc,omitempty"`
+	ServerLimits         *LimitsSettings    `json:"server_limits,omitempty"`
+	ServerTokens         []TokenSettings    `json:"server_tokens,omitempty"`
+	ServerAccessLog      *AccessLogSettings `json:"server_access_log,omitempty"`
+	ServerDrainTimeout   string             `json:"server_drain_timeout,omitempty"` // e.g. "30s"
+	ServerRegistry       string             `json:"server_registry,omitempty"`      // "memory" or a redis:// URL
+	ServerNodeAddr       string             `json:"server_node_addr,omitempty"`
+	ServerClusterSecret  string             `json:"server_cluster_secret,omitempty"`
+	ServerDB             string             `json:"server_db,omitempty"` // SQLite store path
+	ServerTLSCert        string             `json:"server_tls_cert,omitempty"`
+	ServerTLSKey         string             `json:"server_tls_key,omitempty"`
+
+	encrypted bool // whether Save encrypts the secrets
+}
+
+// AccessLogSettings configures the server's per-request access log.
+type AccessLogSettings struct {
+	Path       string `json:"path"`
+	Format     string `json:"format,omitempty"` // "json" or "combined"
+	MaxSizeMB  int    `json:"max_size_mb,omitempty"`
+	MaxBackups int    `json:"max_backups,omitempty"`
+}
+
+// TokenSettings is an additional server auth token with optional quotas;
+// zero quota values mean unlimited.
+type TokenSettings struct {
+	Name             string `json:"name"`
+	Token            string `json:"token"`
+	BytesPerDay      int64  `json:"bytes_per_day,omitempty"`
+	RequestsPerMonth int64  `json:"requests_per_month,omitempty"`
+	MaxTunnels       int    `json:"max_tunnels,omitempty"`
+}
+
+// LimitSettings configures a token bucket and an in-flight cap; zero
+// disables each.
+type LimitSettings struct {
+	RequestsPerSecond float64 `json:"requests_per_second,omitempty"`
+	Burst             int     `json:"burst,omitempty"`
+	MaxInFlight       int     `json:"max_in_flight,omitempty"`
+}
+
+// LimitsSettings holds the server's per tunnel, per token and per IP limits.
+type LimitsSettings struct {
+	Tunnel LimitSettings `json:"tunnel"`
+	Token  LimitSettings `json:"token"`
+	IP     LimitSettings `json:"ip"`
+}
+
+// OIDCSettings configures the server's optional OIDC login gateway.
+type OIDCSettings struct {
+	IssuerURL      string   `json:"issuer_url"`
+	ClientID       string   `json:"client_id"`
+	ClientSecret   string   `json:"client_secret,omitempty"`
+	Scopes         []string `json:"scopes,omitempty"`
+	CookieSecret   string   `json:"cookie_secret,omitempty"`
+	SessionTTL     string   `json:"session_ttl,omitempty"` // e.g. "12h"
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	AllowedGroups  []string `json:"allowed_groups,omitempty"`
+	GroupsClaim    string   `json:"groups_claim,omitempty"`
 }
 
 func GetConfigDir() (string, error) {
@@ -22,127 +88,341 @@ func GetConfigDir() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to get user home directory: %w", err)
 	}
-	
+
 	configDir := filepath.Join(homeDir, ".config", "dr1ll")
 	return configDir, nil
 }
 
-func GetConfigPath() (string, error) {
+// GetServerConfigPath returns the location of the server's config file.
+func GetServerConfigPath() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "server.json"), nil
+}
+
+// GetClientConfigPath returns the location of the client's config file.
+func GetClientConfigPath() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "client.json"), nil
+}
+
+// getLegacyConfigPath returns the config.json older versions shared between
+// the client and the server.
+func getLegacyConfigPath() (string, error) {
 	configDir, err := GetConfigDir()
 	if err != nil {
 		return "", err
 	}
-	
+
 	return filepath.Join(configDir, "config.json"), nil
 }
 
+// GetUsagePath returns where the server persists per-token usage counters.
+func GetUsagePath() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "usage.json"), nil
+}
+
+// GetStorePath returns the default location of the server's SQLite store.
+func GetStorePath() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "dr1ll.db"), nil
+}
+
+// GetControlDir returns the directory holding the control sockets of
+// running clients, creating it if needed: $XDG_RUNTIME_DIR/dr1ll when set,
+// otherwise a run directory in the config directory.
+func GetControlDir() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir != "" {
+		dir = filepath.Join(dir, "dr1ll")
+	} else {
+		configDir, err := GetConfigDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(configDir, "run")
+	}
+
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return "", err
+	}
+	// The control sockets live here, so keep others out even if the
+	// directory already existed with looser permissions.
+	if err := os.Chmod(dir, dirMode); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
 func EnsureConfigDir() error {
 	configDir, err := GetConfigDir()
 	if err != nil {
 		return err
 	}
-	
-	return os.MkdirAll(configDir, 0755)
+
+	if err := os.MkdirAll(configDir, dirMode); err != nil {
+		return err
+	}
+	checkPermissions(configDir)
+	return nil
 }
 
-func Load() (*Config, error) {
-	configPath, err := GetConfigPath()
+// LoadServer reads and validates the server config: the user's server.json
+// with the fields of ./dr1ll-server.json, if present, taking precedence. It
+// returns ErrNoConfig if neither file exists.
+func LoadServer() (*ServerConfig, error) {
+	config, err := readServer()
+	userMissing := errors.Is(err, ErrNoConfig)
+	if userMissing {
+		config, err = &ServerConfig{}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	
-	// Return default config if file doesn't exist
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return &Config{
-			TunnelServer: "http://localhost:9090",
-			Token:        "some-hard-coded-token",
-			ServerPort:   "9090",
-			ServerDomain: "mydomain.com",
-			ServerToken:  "some-hard-coded-token",
-		}, nil
+
+	err = readJSON(ProjectServerConfigName, config)
+	if errors.Is(err, ErrNoConfig) {
+		if userMissing {
+			return nil, ErrNoConfig
+		}
+		err = nil
 	}
-	
-	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return nil, err
 	}
-	
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	if _, err := decryptSecrets(config.secrets()); err != nil {
+		return nil, err
 	}
-	
-	return &config, nil
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid server config: %w", err)
+	}
+
+	return config, nil
 }
 
-func (c *Config) Save() error {
-	if err := EnsureConfigDir(); err != nil {
-		return err
+// readServer reads the server config without validating it.
+func readServer() (*ServerConfig, error) {
+	if err := migrateLegacyConfig(); err != nil {
+		return nil, err
 	}
-	
-	configPath, err := GetConfigPath()
+
+	configPath, err := GetServerConfigPath()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	
-	data, err := json.MarshalIndent(c, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
+
+	var config ServerConfig
+	if err := readJSON(configPath, &config); err != nil {
+		return nil, err
 	}
-	
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write config file: %w", err)
+	if config.encrypted, err = decryptSecrets(config.secrets()); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", configPath, err)
 	}
-	
-	return nil
+
+	return &config, nil
 }
 
-func SetServer(server string) error {
-	config, err := Load()
+// Save validates and writes the server config.
+func (c *ServerConfig) Save() error {
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	configPath, err := GetServerConfigPath()
 	if err != nil {
 		return err
 	}
-	
-	config.TunnelServer = server
-	return config.Save()
+
+	if !c.encrypted {
+		return writeJSON(configPath, c)
+	}
+	return withEncryptedSecrets(c.secrets(), func() error {
+		return writeJSON(configPath, c)
+	})
 }
 
-func SetToken(token string) error {
-	config, err := Load()
-	if err != nil {
-		return err
+// Encrypted reports whether the config file stores its secrets encrypted.
+func (c *ServerConfig) Encrypted() bool {
+	return c.encrypted
+}
+
+// secrets returns pointers to the secret fields, which are encrypted at
+// rest when encryption is enabled.
+func (c *ServerConfig) secrets() []*string {
+	secrets := []*string{&c.ServerToken, &c.ServerAdminToken, &c.ServerClusterSecret}
+	for i := range c.ServerTokens {
+		secrets = append(secrets, &c.ServerTokens[i].Token)
 	}
-	
-	config.Token = token
-	return config.Save()
+	if c.ServerOIDC != nil {
+		secrets = append(secrets, &c.ServerOIDC.ClientSecret, &c.ServerOIDC.CookieSecret)
+	}
+	return secrets
+}
+
+// loadServerOrEmpty loads the server config for a setter, starting from an
+// empty one if none exists yet. It skips validation so a setter can fix an
+// invalid file; Save validates the result.
+func loadServerOrEmpty() (*ServerConfig, error) {
+	config, err := readServer()
+	if errors.Is(err, ErrNoConfig) {
+		return &ServerConfig{}, nil
+	}
+	return config, err
 }
 
 func SetServerDomain(domain string) error {
-	config, err := Load()
+	config, err := loadServerOrEmpty()
 	if err != nil {
 		return err
 	}
-	
+
 	config.ServerDomain = domain
 	return config.Save()
 }
 
 func SetServerPort(port string) error {
-	config, err := Load()
+	config, err := loadServerOrEmpty()
 	if err != nil {
 		return err
 	}
-	
+
 	config.ServerPort = port
 	return config.Save()
 }
 
 func SetServerToken(token string) error {
-	config, err := Load()
+	config, err := loadServerOrEmpty()
 	if err != nil {
 		return err
 	}
-	
+
 	config.ServerToken = token
 	return config.Save()
-}
\ No newline at end of file
+}
+
+// SetServerEncryption turns encryption of the server config's secrets on
+// or off, rewriting the file. Either way the key must be set if the file
+// is currently encrypted.
+func SetServerEncryption(enabled bool) error {
+	config, err := readServer()
+	if err != nil {
+		return err
+	}
+
+	config.encrypted = enabled
+	return config.Save()
+}
+
+// readJSON decodes the config file at path into v, returning ErrNoConfig if
+// it does not exist.
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ErrNoConfig
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	checkPermissions(path)
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// writeJSON writes v as an indented config file at path.
+func writeJSON(path string, v any) error {
+	if err := EnsureConfigDir(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	if err := writeFile(path, data); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	return nil
+}
+
+// migrateLegacyConfig splits the shared config.json of older versions into
+// client.json, as the default profile, and server.json. Files that already
+// exist are left alone, and config.json is renamed so this runs once. The
+// files are copied as-is; problems surface when they are next loaded.
+func migrateLegacyConfig() error {
+	legacyPath, err := getLegacyConfigPath()
+	if err != nil {
+		return err
+	}
+
+	var legacy struct {
+		TunnelServer string `json:"tunnel_server"`
+		Token        string `json:"token"`
+	}
+	if err := readJSON(legacyPath, &legacy); err != nil {
+		if errors.Is(err, ErrNoConfig) {
+			return nil
+		}
+		return err
+	}
+	var serverConfig ServerConfig
+	if err := readJSON(legacyPath, &serverConfig); err != nil {
+		return err
+	}
+
+	clientPath, err := GetClientConfigPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(clientPath); os.IsNotExist(err) && (legacy.TunnelServer != "" || legacy.Token != "") {
+		clientConfig := &ClientConfig{
+			CurrentProfile: DefaultProfile,
+			Profiles: map[string]*Profile{
+				DefaultProfile: {TunnelServer: legacy.TunnelServer, Token: legacy.Token},
+			},
+		}
+		if err := writeJSON(clientPath, clientConfig); err != nil {
+			return err
+		}
+	}
+
+	serverPath, err := GetServerConfigPath()
+	if err != nil {
+		return err
+	}
+	if data, _ := json.Marshal(serverConfig); string(data) != "{}" {
+		if _, err := os.Stat(serverPath); os.IsNotExist(err) {
+			if err := writeJSON(serverPath, serverConfig); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.Rename(legacyPath, legacyPath+".migrated"); err != nil {
+		return fmt.Errorf("failed to retire legacy config file: %w", err)
+	}
+
+	return nil
+}
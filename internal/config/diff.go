@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// Change describes a config field that differs between two versions.
+type Change struct {
+	Field string // JSON name, e.g. "server_limits"
+	Old   string
+	New   string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Field, c.Old, c.New)
+}
+
+// DiffServer lists the fields that differ between two server configs.
+// Values of secret fields and token lists are redacted.
+func DiffServer(old, new *ServerConfig) []Change {
+	var changes []Change
+	oldValue, newValue := reflect.ValueOf(*old), reflect.ValueOf(*new)
+	for i := 0; i < oldValue.NumField(); i++ {
+		field := oldValue.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		a, b := oldValue.Field(i).Interface(), newValue.Field(i).Interface()
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		change := Change{Field: name, Old: "(changed)", New: "(changed)"}
+		if !isSecretField(name) {
+			change.Old, change.New = diffValue(a), diffValue(b)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func isSecretField(name string) bool {
+	return strings.Contains(name, "token") || strings.Contains(name, "secret") || name == "server_oidc"
+}
+
+// diffValue formats v for a change, masking the password of URLs such as
+// the Redis registry's.
+func diffValue(v any) string {
+	if s, ok := v.(string); ok {
+		if u, err := url.Parse(s); err == nil && u.User != nil {
+			v = u.Redacted()
+		}
+	}
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == `""` || string(data) == "null" {
+		return "(unset)"
+	}
+	return string(data)
+}
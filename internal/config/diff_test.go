@@ -0,0 +1,65 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffServer(t *testing.T) {
+	tests := []struct {
+		name string
+		old  ServerConfig
+		new  ServerConfig
+		want []Change
+	}{
+		{
+			name: "unchanged",
+			old:  ServerConfig{ServerPort: "9090", ServerAllowIPs: []string{"10.0.0.0/8"}},
+			new:  ServerConfig{ServerPort: "9090", ServerAllowIPs: []string{"10.0.0.0/8"}},
+		},
+		{
+			name: "plain values",
+			old:  ServerConfig{ServerPort: "9090"},
+			new:  ServerConfig{ServerPort: "8080", ServerAllowIPs: []string{"10.0.0.0/8"}},
+			want: []Change{
+				{Field: "server_port", Old: `"9090"`, New: `"8080"`},
+				{Field: "server_allow_ips", Old: "(unset)", New: `["10.0.0.0/8"]`},
+			},
+		},
+		{
+			name: "secrets are redacted",
+			old:  ServerConfig{ServerToken: "old", ServerTokens: []TokenSettings{{Name: "ci", Token: "a"}}},
+			new:  ServerConfig{ServerToken: "new", ServerTokens: []TokenSettings{{Name: "ci", Token: "b"}}},
+			want: []Change{
+				{Field: "server_token", Old: "(changed)", New: "(changed)"},
+				{Field: "server_tokens", Old: "(changed)", New: "(changed)"},
+			},
+		},
+		{
+			name: "OIDC settings are redacted",
+			old:  ServerConfig{},
+			new:  ServerConfig{ServerOIDC: &OIDCSettings{}},
+			want: []Change{{Field: "server_oidc", Old: "(changed)", New: "(changed)"}},
+		},
+		{
+			name: "registry URL password is masked",
+			old:  ServerConfig{ServerRegistry: "memory"},
+			new:  ServerConfig{ServerRegistry: "redis://:hunter2@redis:6379/0"},
+			want: []Change{{Field: "server_registry", Old: `"memory"`, New: `"redis://:xxxxx@redis:6379/0"`}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffServer(&tt.old, &tt.new); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffServer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangeString(t *testing.T) {
+	c := Change{Field: "server_port", Old: `"9090"`, New: `"8080"`}
+	if got, want := c.String(), `server_port: "9090" -> "8080"`; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// Config files hold tokens, so they are only readable by their owner.
+const (
+	fileMode fs.FileMode = 0600
+	dirMode  fs.FileMode = 0700
+)
+
+var (
+	warnedMu sync.Mutex
+	warned   = make(map[string]bool)
+)
+
+// checkPermissions warns, once per path, when a file holding secrets can be
+// read or written by other users. Windows does not use Unix permission
+// bits, so nothing is checked there.
+func checkPermissions(path string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm()&0077 == 0 {
+		return
+	}
+
+	warnedMu.Lock()
+	defer warnedMu.Unlock()
+	if warned[path] {
+		return
+	}
+	warned[path] = true
+
+	want := fileMode
+	if info.IsDir() {
+		want = dirMode
+	}
+	slog.Warn("file holding secrets is accessible by other users",
+		"path", path, "mode", fmt.Sprintf("%04o", info.Mode().Perm()), "fix", fmt.Sprintf("chmod %o %s", want, path))
+}
+
+// writeFile writes data to path, tightening the permissions of a file that
+// already exists, since os.WriteFile only applies them to new files.
+func writeFile(path string, data []byte) error {
+	if err := os.WriteFile(path, data, fileMode); err != nil {
+		return err
+	}
+	return os.Chmod(path, fileMode)
+}
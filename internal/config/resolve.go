@@ -0,0 +1,327 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectServerConfigName is the server config file looked up in the
+// current directory, overriding the user's server.json.
+const ProjectServerConfigName = "dr1ll-server.json"
+
+// Source identifies the layer a resolved setting came from.
+type Source string
+
+const (
+	SourceFlag    Source = "flag"
+	SourceEnv     Source = "env"
+	SourceProject Source = "project file"
+	SourceUser    Source = "user file"
+	SourceDefault Source = "default"
+)
+
+// Option describes a setting that can be given on the command line, in the
+// environment or in a config file. Key is its config file name, with dots
+// for nested fields; its environment variable is DR1LL_ followed by the
+// upper-cased key, and Aliases lists older names still honored.
+type Option struct {
+	Key     string
+	Flag    string // empty if there is no command line flag
+	Aliases []string
+	Default string
+	Usage   string
+	Secret  bool // masked when displayed
+}
+
+// Env returns the option's environment variable.
+func (o Option) Env() string {
+	return "DR1LL_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(o.Key))
+}
+
+// Layer is one config file's settings, flattened to option keys.
+type Layer struct {
+	Source Source
+	Path   string
+	Values map[string]string
+}
+
+// Setting is a resolved option value and where it came from.
+type Setting struct {
+	Option
+	Value  string
+	Source Source
+	Origin string // the flag, variable or file that supplied the value
+}
+
+// Display returns the value for printing, masking secrets.
+func (s Setting) Display() string {
+	switch {
+	case s.Value == "":
+		return "(not set)"
+	case s.Secret:
+		return s.Value[:min(len(s.Value), 8)] + "***"
+	default:
+		return s.Value
+	}
+}
+
+// Resolver resolves options from, in order of precedence, command line
+// flags, environment variables, the project config file, the user config
+// file and built-in defaults.
+type Resolver struct {
+	options []Option
+	flags   map[string]string // explicitly set flags, by name
+	layers  []Layer           // project first
+}
+
+// NewResolver resolves options using the flags set in fs, which may be nil,
+// and the given file layers, highest precedence first.
+func NewResolver(fs *flag.FlagSet, options []Option, layers ...Layer) *Resolver {
+	r := &Resolver{options: options, flags: make(map[string]string), layers: layers}
+	if fs != nil {
+		fs.Visit(func(f *flag.Flag) {
+			r.flags[f.Name] = f.Value.String()
+		})
+	}
+	return r
+}
+
+// RegisterFlags defines a string flag, with its built-in default, for
+// every option that has one.
+func RegisterFlags(fs *flag.FlagSet, options []Option) {
+	for _, opt := range options {
+		if opt.Flag != "" {
+			fs.String(opt.Flag, opt.Default, opt.Usage)
+		}
+	}
+}
+
+// Get returns the value of the option with key. It panics on an unknown
+// key, which is a programming error.
+func (r *Resolver) Get(key string) string {
+	return r.Setting(key).Value
+}
+
+// Setting resolves the option with key, reporting where its value came
+// from.
+func (r *Resolver) Setting(key string) Setting {
+	for _, opt := range r.options {
+		if opt.Key == key {
+			return r.resolve(opt)
+		}
+	}
+	panic("config: unknown option " + key)
+}
+
+// Settings resolves every option.
+func (r *Resolver) Settings() []Setting {
+	settings := make([]Setting, 0, len(r.options))
+	for _, opt := range r.options {
+		settings = append(settings, r.resolve(opt))
+	}
+	return settings
+}
+
+func (r *Resolver) resolve(opt Option) Setting {
+	if opt.Flag != "" {
+		if value, ok := r.flags[opt.Flag]; ok {
+			return Setting{Option: opt, Value: value, Source: SourceFlag, Origin: "-" + opt.Flag}
+		}
+	}
+	for _, env := range append([]string{opt.Env()}, opt.Aliases...) {
+		if value := os.Getenv(env); value != "" {
+			return Setting{Option: opt, Value: value, Source: SourceEnv, Origin: env}
+		}
+	}
+	for _, layer := range r.layers {
+		if value, ok := layer.Values[opt.Key]; ok && value != "" {
+			return Setting{Option: opt, Value: value, Source: layer.Source, Origin: layer.Path}
+		}
+	}
+	return Setting{Option: opt, Value: opt.Default, Source: SourceDefault}
+}
+
+// commonOptions are shared by both binaries.
+var commonOptions = []Option{
+	{Key: "log_level", Flag: "log-level", Default: "info", Usage: "Log level: debug, info, warn or error"},
+	{Key: "log_format", Flag: "log-format", Default: "text", Usage: "Log format: text or json"},
+	{Key: "otlp_endpoint", Flag: "otlp-endpoint", Aliases: []string{"OTEL_EXPORTER_OTLP_ENDPOINT"}, Usage: "OTLP/HTTP collector URL for traces"},
+}
+
+// ClientOptions are the options the client resolves.
+func ClientOptions() []Option {
+	return append([]Option{
+		{Key: "profile", Flag: "profile", Default: DefaultProfile, Usage: "Config profile to use"},
+		{Key: "tunnel_server", Flag: "server", Usage: "Tunnel server URL"},
+		{Key: "token", Flag: "token", Secret: true, Usage: "Authentication token"},
+		{Key: "drain_timeout", Flag: "drain-timeout", Default: "30s", Usage: "How long Ctrl+C waits for in-flight requests"},
+	}, commonOptions...)
+}
+
+// ServerOptions are the options the server resolves. TUNNEL_* variables
+// from older versions remain aliases.
+func ServerOptions() []Option {
+	storePath, _ := GetStorePath()
+	return append([]Option{
+		{Key: "server_port", Flag: "port", Aliases: []string{"TUNNEL_PORT"}, Default: "9090", Usage: "Server port"},
+		{Key: "server_domain", Flag: "domain", Aliases: []string{"TUNNEL_DOMAIN"}, Default: "mydomain.com", Usage: "Server domain"},
+		{Key: "server_token", Flag: "token", Aliases: []string{"TUNNEL_TOKEN"}, Secret: true, Usage: "Authentication token"},
+		{Key: "server_admin_addr", Flag: "admin-addr", Aliases: []string{"TUNNEL_ADMIN_ADDR"}, Usage: "Admin listener address for /metrics and the API"},
+		{Key: "server_admin_token", Flag: "admin-token", Aliases: []string{"TUNNEL_ADMIN_TOKEN"}, Secret: true, Usage: "Bearer token enabling the admin API"},
+		{Key: "server_access_log.path", Flag: "access-log", Aliases: []string{"TUNNEL_ACCESS_LOG"}, Usage: "Access log file path"},
+		{Key: "server_access_log.format", Flag: "access-log-format", Aliases: []string{"TUNNEL_ACCESS_LOG_FORMAT"}, Default: "json", Usage: "Access log format: json or combined"},
+		{Key: "server_trusted_proxies", Flag: "trusted-proxies", Aliases: []string{"TUNNEL_TRUSTED_PROXIES"}, Usage: "Comma-separated CIDRs of trusted proxies"},
+		{Key: "server_allow_ips", Flag: "allow-ips", Aliases: []string{"TUNNEL_ALLOW_IPS"}, Usage: "Comma-separated CIDRs allowed server-wide"},
+		{Key: "server_deny_ips", Flag: "deny-ips", Aliases: []string{"TUNNEL_DENY_IPS"}, Usage: "Comma-separated CIDRs blocked server-wide"},
+		{Key: "server_drain_timeout", Flag: "drain-timeout", Aliases: []string{"TUNNEL_DRAIN_TIMEOUT"}, Default: "30s", Usage: "How long shutdown waits for in-flight requests"},
+		{Key: "server_registry", Flag: "registry", Aliases: []string{"TUNNEL_REGISTRY"}, Default: "memory", Usage: "Tunnel registry: memory or a redis:// URL shared by all nodes"},
+		{Key: "server_node_addr", Flag: "node-addr", Aliases: []string{"TUNNEL_NODE_ADDR"}, Usage: "host:port other nodes reach this node at"},
+		{Key: "server_cluster_secret", Flag: "cluster-secret", Aliases: []string{"TUNNEL_CLUSTER_SECRET"}, Secret: true, Usage: "Secret authenticating requests forwarded between nodes (required with a Redis registry)"},
+		{Key: "server_db", Flag: "db", Aliases: []string{"TUNNEL_DB"}, Default: storePath, Usage: "SQLite store path"},
+		{Key: "server_tls_cert", Flag: "tls-cert", Aliases: []string{"TUNNEL_TLS_CERT"}, Usage: "TLS certificate file, enables HTTPS"},
+		{Key: "server_tls_key", Flag: "tls-key", Aliases: []string{"TUNNEL_TLS_KEY"}, Usage: "TLS private key file"},
+		{Key: "server_oidc.issuer_url", Flag: "oidc-issuer", Aliases: []string{"TUNNEL_OIDC_ISSUER"}, Usage: "OIDC issuer URL"},
+		{Key: "server_oidc.client_id", Flag: "oidc-client-id", Aliases: []string{"TUNNEL_OIDC_CLIENT_ID"}, Usage: "OIDC client ID"},
+		{Key: "server_oidc.client_secret", Flag: "oidc-client-secret", Aliases: []string{"TUNNEL_OIDC_CLIENT_SECRET"}, Secret: true, Usage: "OIDC client secret"},
+		{Key: "server_oidc.cookie_secret", Aliases: []string{"TUNNEL_OIDC_COOKIE_SECRET"}, Secret: true},
+	}, commonOptions...)
+}
+
+// NewServerResolver resolves server options from fs, the environment,
+// ./dr1ll-server.json and the user's server.json.
+func NewServerResolver(fs *flag.FlagSet, options []Option) (*Resolver, error) {
+	project, err := readLayer(SourceProject, ProjectServerConfigName)
+	if err != nil {
+		return nil, err
+	}
+	userPath, err := GetServerConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	user, err := readLayer(SourceUser, userPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewResolver(fs, options, project, user), nil
+}
+
+// NewClientResolver resolves client options from fs, the environment,
+// ./dr1ll.yaml and the selected profile of the user's client.json. It
+// fails if a profile was asked for explicitly but does not exist.
+//
+// The profile's token is only used with the profile's server: a project
+// file could otherwise point the client at any server and collect the
+// token. When the server comes from elsewhere the token must too.
+func NewClientResolver(fs *flag.FlagSet, options []Option) (*Resolver, error) {
+	project, err := readLayer(SourceProject, TunnelsFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := LoadClient()
+	if errors.Is(err, ErrNoConfig) {
+		cfg, err = &ClientConfig{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	userPath, err := GetClientConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	user := Layer{Source: SourceUser, Path: userPath, Values: map[string]string{"profile": cfg.CurrentProfile}}
+
+	r := NewResolver(fs, options, project, user)
+	profile := r.Setting("profile")
+	if p, ok := cfg.Profiles[profile.Value]; ok {
+		user.Values["tunnel_server"] = p.TunnelServer
+		user.Values["token"] = p.Token
+	} else if profile.Source != SourceDefault {
+		return nil, fmt.Errorf("profile %q (from %s %s) not found", profile.Value, profile.Source, profile.Origin)
+	}
+
+	server, token := r.Setting("tunnel_server"), r.Setting("token")
+	if server.Source != SourceUser && server.Value != user.Values["tunnel_server"] && token.Source == SourceUser {
+		delete(user.Values, "token")
+		slog.Warn("not sending the profile's token to a server it was not saved for, pass -token or set "+token.Env(),
+			"server", server.Value, "source", server.Source, "origin", server.Origin)
+	}
+	return r, nil
+}
+
+// readLayer reads a JSON or YAML config file into a layer. A missing file
+// gives an empty layer.
+func readLayer(source Source, path string) (Layer, error) {
+	layer := Layer{Source: source, Path: path, Values: make(map[string]string)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return layer, nil
+	}
+	if err != nil {
+		return layer, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]any
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		err = yaml.Unmarshal(data, &values)
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&values)
+	}
+	if err != nil {
+		return layer, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	flatten("", values, layer.Values)
+	for key, value := range layer.Values {
+		if layer.Values[key], err = decryptSecret(value); err != nil {
+			return layer, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+	return layer, nil
+}
+
+// flatten turns nested objects into dotted keys and lists of scalars into
+// comma-separated values.
+func flatten(prefix string, values map[string]any, out map[string]string) {
+	for key, value := range values {
+		switch v := value.(type) {
+		case map[string]any:
+			flatten(prefix+key+".", v, out)
+		case []any:
+			if isScalarList(v) {
+				items := make([]string, len(v))
+				for i, item := range v {
+					items[i] = fmt.Sprint(item)
+				}
+				out[prefix+key] = strings.Join(items, ",")
+			} else {
+				data, _ := json.Marshal(v)
+				out[prefix+key] = string(data)
+			}
+		case nil:
+		default:
+			out[prefix+key] = fmt.Sprint(v)
+		}
+	}
+}
+
+func isScalarList(items []any) bool {
+	for _, item := range items {
+		switch item.(type) {
+		case map[string]any, []any:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,245 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolverPrecedence(t *testing.T) {
+	options := []Option{
+		{Key: "server_port", Flag: "port", Aliases: []string{"TUNNEL_PORT"}, Default: "9090"},
+	}
+	project := Layer{Source: SourceProject, Path: "dr1ll-server.json", Values: map[string]string{"server_port": "1"}}
+	user := Layer{Source: SourceUser, Path: "server.json", Values: map[string]string{"server_port": "2"}}
+
+	tests := []struct {
+		name   string
+		flag   string
+		env    string
+		alias  string
+		layers []Layer
+		want   Setting
+	}{
+		{
+			name: "default",
+			want: Setting{Value: "9090", Source: SourceDefault},
+		},
+		{
+			name:   "user file",
+			layers: []Layer{user},
+			want:   Setting{Value: "2", Source: SourceUser, Origin: "server.json"},
+		},
+		{
+			name:   "project file over user file",
+			layers: []Layer{project, user},
+			want:   Setting{Value: "1", Source: SourceProject, Origin: "dr1ll-server.json"},
+		},
+		{
+			name:   "empty file value is skipped",
+			layers: []Layer{{Source: SourceProject, Values: map[string]string{"server_port": ""}}, user},
+			want:   Setting{Value: "2", Source: SourceUser, Origin: "server.json"},
+		},
+		{
+			name:   "legacy alias over files",
+			alias:  "3",
+			layers: []Layer{project, user},
+			want:   Setting{Value: "3", Source: SourceEnv, Origin: "TUNNEL_PORT"},
+		},
+		{
+			name:   "environment over alias",
+			env:    "4",
+			alias:  "3",
+			layers: []Layer{project, user},
+			want:   Setting{Value: "4", Source: SourceEnv, Origin: "DR1LL_SERVER_PORT"},
+		},
+		{
+			name:   "flag over everything",
+			flag:   "5",
+			env:    "4",
+			layers: []Layer{project, user},
+			want:   Setting{Value: "5", Source: SourceFlag, Origin: "-port"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DR1LL_SERVER_PORT", tt.env)
+			t.Setenv("TUNNEL_PORT", tt.alias)
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			RegisterFlags(fs, options)
+			var args []string
+			if tt.flag != "" {
+				args = []string{"-port", tt.flag}
+			}
+			if err := fs.Parse(args); err != nil {
+				t.Fatal(err)
+			}
+
+			got := NewResolver(fs, options, tt.layers...).Setting("server_port")
+			tt.want.Option = options[0]
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Setting = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOptionEnv(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"token", "DR1LL_TOKEN"},
+		{"server_oidc.client_id", "DR1LL_SERVER_OIDC_CLIENT_ID"},
+		{"drain-timeout", "DR1LL_DRAIN_TIMEOUT"},
+	}
+	for _, tt := range tests {
+		if got := (Option{Key: tt.key}).Env(); got != tt.want {
+			t.Errorf("Env(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestSettingDisplay(t *testing.T) {
+	tests := []struct {
+		setting Setting
+		want    string
+	}{
+		{Setting{Value: ""}, "(not set)"},
+		{Setting{Value: "9090"}, "9090"},
+		{Setting{Option: Option{Secret: true}, Value: "abc"}, "abc***"},
+		{Setting{Option: Option{Secret: true}, Value: "0123456789abcdef"}, "01234567***"},
+	}
+	for _, tt := range tests {
+		if got := tt.setting.Display(); got != tt.want {
+			t.Errorf("Display(%q) = %q, want %q", tt.setting.Value, got, tt.want)
+		}
+	}
+}
+
+func TestReadLayer(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "JSON",
+			file:    "server.json",
+			content: `{"server_port": "8080", "server_allow_ips": ["10.0.0.0/8", "192.0.2.1"], "server_oidc": {"client_id": "app"}, "server_limits": {"tunnel": {"burst": 10}}, "unset": null}`,
+			want: map[string]string{
+				"server_port":                "8080",
+				"server_allow_ips":           "10.0.0.0/8,192.0.2.1",
+				"server_oidc.client_id":      "app",
+				"server_limits.tunnel.burst": "10",
+			},
+		},
+		{
+			name:    "YAML",
+			file:    "dr1ll.yaml",
+			content: "tunnel_server: https://example.com\ntunnels:\n  - name: web\n    port: 3000\n",
+			want: map[string]string{
+				"tunnel_server": "https://example.com",
+				"tunnels":       `[{"name":"web","port":3000}]`,
+			},
+		},
+		{
+			name: "missing file",
+			file: "absent.json",
+			want: map[string]string{},
+		},
+		{
+			name:    "invalid JSON",
+			file:    "broken.json",
+			content: `{"server_port": `,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			layer, err := readLayer(SourceUser, path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readLayer error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(layer.Values, tt.want) {
+				t.Errorf("Values = %v, want %v", layer.Values, tt.want)
+			}
+		})
+	}
+}
+
+// The profile's token must only be sent to the profile's own server.
+func TestClientResolverToken(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+	t.Setenv("DR1LL_TUNNEL_SERVER", "")
+	t.Setenv("DR1LL_TOKEN", "")
+	if err := SetServer(DefaultProfile, "https://tunnel.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetToken(DefaultProfile, "profile-token"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		project   string
+		args      []string
+		wantToken string
+	}{
+		{name: "profile server", wantToken: "profile-token"},
+		{name: "same server from a flag", args: []string{"-server", "https://tunnel.example.com"}, wantToken: "profile-token"},
+		{name: "other server from a flag", args: []string{"-server", "https://evil.example.com"}},
+		{name: "other server from a project file", project: "tunnel_server: https://evil.example.com\n"},
+		{name: "other server with its own token", args: []string{"-server", "https://evil.example.com", "-token", "t2"}, wantToken: "t2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(TunnelsFileName)
+			if tt.project != "" {
+				if err := os.WriteFile(TunnelsFileName, []byte(tt.project), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			options := ClientOptions()
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			RegisterFlags(fs, options)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := NewClientResolver(fs, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.Get("token"); got != tt.wantToken {
+				t.Errorf("token = %q, want %q", got, tt.wantToken)
+			}
+		})
+	}
+}
+
+func TestClientResolverUnknownProfile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+
+	options := ClientOptions()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(fs, options)
+	fs.Parse([]string{"-profile", "missing"})
+	if _, err := NewClientResolver(fs, options); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Environment variables supplying the key that encrypts tokens at rest.
+const (
+	PassphraseEnv = "DR1LL_PASSPHRASE"
+	KeyFileEnv    = "DR1LL_KEY_FILE"
+)
+
+// encryptedPrefix marks an encrypted value: the prefix is followed by the
+// base64 of the PBKDF2 salt, the GCM nonce and the sealed value.
+const encryptedPrefix = "enc:v1:"
+
+const (
+	saltSize      = 16
+	keyIterations = 600000
+	keySize       = 32 // AES-256
+)
+
+// ErrNoPassphrase is returned when values have to be encrypted or
+// decrypted but no key has been supplied.
+var ErrNoPassphrase = errors.New("no encryption key set, use " + PassphraseEnv + " or " + KeyFileEnv)
+
+var (
+	keysMu sync.Mutex
+	keys   = make(map[string][]byte) // derived keys, by passphrase and salt
+	salt   []byte                    // salt for values encrypted by this process
+)
+
+// IsEncrypted reports whether value is an encrypted secret.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// passphrase returns the encryption passphrase from DR1LL_PASSPHRASE or the
+// contents of the file named by DR1LL_KEY_FILE.
+func passphrase() (string, error) {
+	if value := os.Getenv(PassphraseEnv); value != "" {
+		return value, nil
+	}
+	path := os.Getenv(KeyFileEnv)
+	if path == "" {
+		return "", ErrNoPassphrase
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read key file: %w", err)
+	}
+	checkPermissions(path)
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("key file %s is empty", path)
+	}
+	return value, nil
+}
+
+// deriveKey derives the AES key for passphrase and salt. PBKDF2 is slow on
+// purpose, so keys are cached for the life of the process.
+func deriveKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	id := passphrase + "\x00" + string(salt)
+	key, ok := keys[id]
+	if !ok {
+		var err error
+		key, err = pbkdf2.Key(sha256.New, passphrase, salt, keyIterations, keySize)
+		if err != nil {
+			return nil, err
+		}
+		keys[id] = key
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptSecret encrypts value. Empty and already encrypted values are
+// returned unchanged.
+func encryptSecret(value string) (string, error) {
+	if value == "" || IsEncrypted(value) {
+		return value, nil
+	}
+	pass, err := passphrase()
+	if err != nil {
+		return "", err
+	}
+
+	keysMu.Lock()
+	if salt == nil {
+		salt = make([]byte, saltSize)
+		rand.Read(salt)
+	}
+	keysMu.Unlock()
+
+	aead, err := deriveKey(pass, salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+
+	data := append(append(append([]byte{}, salt...), nonce...), aead.Seal(nil, nonce, []byte(value), nil)...)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// decryptSecret decrypts value if it is encrypted and returns it unchanged
+// otherwise.
+func decryptSecret(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	pass, err := passphrase()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil || len(data) < saltSize {
+		return "", errors.New("malformed encrypted value")
+	}
+	aead, err := deriveKey(pass, data[:saltSize])
+	if err != nil {
+		return "", err
+	}
+	data = data[saltSize:]
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt value, wrong passphrase or key file?")
+	}
+	return string(plain), nil
+}
+
+// decryptSecrets decrypts the values in place, reporting whether any was
+// encrypted.
+func decryptSecrets(values []*string) (bool, error) {
+	encrypted := false
+	for _, value := range values {
+		if !IsEncrypted(*value) {
+			continue
+		}
+		plain, err := decryptSecret(*value)
+		if err != nil {
+			return false, err
+		}
+		*value, encrypted = plain, true
+	}
+	return encrypted, nil
+}
+
+// withEncryptedSecrets encrypts the values in place while write runs,
+// restoring the plain values afterwards.
+func withEncryptedSecrets(values []*string, write func() error) error {
+	plain := make([]string, len(values))
+	for i, value := range values {
+		plain[i] = *value
+	}
+	defer func() {
+		for i, value := range values {
+			*value = plain[i]
+		}
+	}()
+
+	for _, value := range values {
+		sealed, err := encryptSecret(*value)
+		if err != nil {
+			return err
+		}
+		*value = sealed
+	}
+	return write()
+}
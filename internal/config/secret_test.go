@@ -0,0 +1,160 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretRoundTrip(t *testing.T) {
+	t.Setenv(PassphraseEnv, "correct horse")
+
+	tests := []string{"token", "with spaces and ünïcode", strings.Repeat("x", 1000)}
+	for _, plain := range tests {
+		sealed, err := encryptSecret(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncrypted(sealed) || strings.Contains(sealed, plain) {
+			t.Fatalf("encryptSecret(%q) = %q", plain, sealed)
+		}
+		again, _ := encryptSecret(plain)
+		if again == sealed {
+			t.Error("encrypting twice gave the same value, the nonce is reused")
+		}
+		if got, err := decryptSecret(sealed); err != nil || got != plain {
+			t.Errorf("decryptSecret = (%q, %v), want %q", got, err, plain)
+		}
+	}
+}
+
+func TestSecretPassthrough(t *testing.T) {
+	t.Setenv(PassphraseEnv, "")
+	t.Setenv(KeyFileEnv, "")
+
+	// Values that need no key work without one.
+	for _, value := range []string{"", "plain"} {
+		if got, err := decryptSecret(value); err != nil || got != value {
+			t.Errorf("decryptSecret(%q) = (%q, %v)", value, got, err)
+		}
+	}
+	if got, err := encryptSecret(""); err != nil || got != "" {
+		t.Errorf("encryptSecret(\"\") = (%q, %v)", got, err)
+	}
+	if got, err := encryptSecret(encryptedPrefix + "abc"); err != nil || got != encryptedPrefix+"abc" {
+		t.Errorf("encrypting an encrypted value = (%q, %v)", got, err)
+	}
+
+	if _, err := encryptSecret("plain"); !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("encryptSecret without a key: error = %v, want ErrNoPassphrase", err)
+	}
+	if _, err := decryptSecret(encryptedPrefix + "abc"); !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("decryptSecret without a key: error = %v, want ErrNoPassphrase", err)
+	}
+}
+
+func TestSecretDecryptErrors(t *testing.T) {
+	t.Setenv(PassphraseEnv, "correct horse")
+	sealed, err := encryptSecret("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		value      string
+		passphrase string
+	}{
+		{name: "wrong passphrase", value: sealed, passphrase: "battery staple"},
+		{name: "not base64", value: encryptedPrefix + "!!!", passphrase: "correct horse"},
+		{name: "too short", value: encryptedPrefix + "AAAA", passphrase: "correct horse"},
+		{name: "tampered", value: sealed[:len(sealed)-4] + "AAAA", passphrase: "correct horse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(PassphraseEnv, tt.passphrase)
+			if got, err := decryptSecret(tt.value); err == nil {
+				t.Errorf("decryptSecret = %q, want an error", got)
+			}
+		})
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(PassphraseEnv, "")
+
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte("correct horse\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(KeyFileEnv, keyFile)
+	sealed, err := encryptSecret("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The trailing newline is not part of the key.
+	t.Setenv(KeyFileEnv, "")
+	t.Setenv(PassphraseEnv, "correct horse")
+	if got, err := decryptSecret(sealed); err != nil || got != "token" {
+		t.Errorf("decryptSecret with the passphrase = (%q, %v)", got, err)
+	}
+
+	t.Setenv(PassphraseEnv, "")
+	empty := filepath.Join(dir, "empty")
+	os.WriteFile(empty, []byte("\n"), 0600)
+	for _, path := range []string{empty, filepath.Join(dir, "missing")} {
+		t.Setenv(KeyFileEnv, path)
+		if _, err := passphrase(); err == nil {
+			t.Errorf("passphrase() with key file %s: want an error", filepath.Base(path))
+		}
+	}
+}
+
+func TestServerConfigEncryption(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+	t.Setenv(PassphraseEnv, "correct horse")
+
+	if err := SetServerToken("server-token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetServerEncryption(true); err != nil {
+		t.Fatal(err)
+	}
+
+	path, _ := GetServerConfigPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "server-token") || !strings.Contains(string(data), encryptedPrefix) {
+		t.Errorf("config file does not hold the token encrypted:\n%s", data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != fileMode {
+		t.Errorf("config file mode = %04o, want %04o", info.Mode().Perm(), fileMode)
+	}
+
+	loaded, err := LoadServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ServerToken != "server-token" {
+		t.Errorf("loaded token = %q", loaded.ServerToken)
+	}
+
+	// Encrypted configs carry unexported state that diffs must skip.
+	changed := *loaded
+	changed.ServerToken = "rotated"
+	if changes := DiffServer(loaded, &changed); len(changes) != 1 || changes[0].Field != "server_token" {
+		t.Errorf("DiffServer = %v", changes)
+	}
+
+	t.Setenv(PassphraseEnv, "")
+	if _, err := LoadServer(); !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("LoadServer without a key: error = %v, want ErrNoPassphrase", err)
+	}
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TunnelsFileName is the name of the file declaring named tunnels.
+const TunnelsFileName = "dr1ll.yaml"
+
+// TunnelsFile declares named tunnels for `dr1ll start <name>...`.
+type TunnelsFile struct {
+	Tunnels map[string]*TunnelSettings `yaml:"tunnels"`
+}
+
+// TunnelSettings describes one tunnel. Upstream is a port, a host:port or
+// an http(s) URL.
+type TunnelSettings struct {
+	Upstream       string           `yaml:"upstream"`
+	Subdomain      string           `yaml:"subdomain,omitempty"`
+	Auth           *TunnelAuth      `yaml:"auth,omitempty"`
+	Headers        *TunnelHeaders   `yaml:"headers,omitempty"`
+	Inspect        *InspectSettings `yaml:"inspect,omitempty"`
+	MaxConcurrency int              `yaml:"max_concurrency,omitempty"`
+}
+
+// TunnelAuth restricts who may reach a tunnel's public URL.
+type TunnelAuth struct {
+	Basic    string      `yaml:"basic,omitempty"` // "user:pass"
+	Bearer   string      `yaml:"bearer,omitempty"`
+	OIDC     *TunnelOIDC `yaml:"oidc,omitempty"`
+	AllowIPs []string    `yaml:"allow_ips,omitempty"`
+	DenyIPs  []string    `yaml:"deny_ips,omitempty"`
+}
+
+// TunnelOIDC requires signing in via the server's OIDC provider, optionally
+// limited to some email domains or groups.
+type TunnelOIDC struct {
+	Domains []string `yaml:"domains,omitempty"`
+	Groups  []string `yaml:"groups,omitempty"`
+}
+
+// TunnelHeaders rewrites request headers before they reach the upstream and
+// response headers before they go back.
+type TunnelHeaders struct {
+	Request  HeaderRuleSettings `yaml:"request,omitempty"`
+	Response HeaderRuleSettings `yaml:"response,omitempty"`
+}
+
+// HeaderRuleSettings removes, then sets, headers.
+type HeaderRuleSettings struct {
+	Set    map[string]string `yaml:"set,omitempty"`
+	Remove []string          `yaml:"remove,omitempty"`
+}
+
+// InspectSettings logs the requests and responses passing through a tunnel.
+type InspectSettings struct {
+	Enabled     bool `yaml:"enabled"`
+	MaxBodySize int  `yaml:"max_body_size,omitempty"`
+}
+
+// FindTunnelsFile returns the path of the tunnels file, looking in the
+// current directory first and then the config directory.
+func FindTunnelsFile() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	for _, path := range []string{TunnelsFileName, filepath.Join(configDir, TunnelsFileName)} {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("no %s found in the current directory or %s", TunnelsFileName, configDir)
+}
+
+// LoadTunnels reads and parses the tunnels file at path.
+func LoadTunnels(path string) (*TunnelsFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tunnels file: %w", err)
+	}
+
+	var file TunnelsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for name, tunnel := range file.Tunnels {
+		if tunnel == nil || tunnel.Upstream == "" {
+			return nil, fmt.Errorf("tunnel %q in %s has no upstream", name, path)
+		}
+	}
+
+	return &file, nil
+}
+
+// Tunnel returns the named tunnel.
+func (f *TunnelsFile) Tunnel(name string) (*TunnelSettings, error) {
+	tunnel, ok := f.Tunnels[name]
+	if !ok {
+		return nil, fmt.Errorf("tunnel %q not defined", name)
+	}
+	return tunnel, nil
+}
+
+// Names returns the names of all declared tunnels, sorted.
+func (f *TunnelsFile) Names() []string {
+	names := make([]string, 0, len(f.Tunnels))
+	for name := range f.Tunnels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
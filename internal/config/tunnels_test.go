@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadTunnels(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]*TunnelSettings
+		wantErr bool
+	}{
+		{
+			name: "tunnels",
+			content: `tunnels:
+  web:
+    upstream: "3000"
+    subdomain: app
+    auth:
+      basic: alice:s3cret
+      allow_ips: [10.0.0.0/8]
+    headers:
+      request:
+        set: {X-Env: dev}
+        remove: [Cookie]
+  api:
+    upstream: https://localhost:8443
+    max_concurrency: 4
+`,
+			want: map[string]*TunnelSettings{
+				"web": {
+					Upstream:  "3000",
+					Subdomain: "app",
+					Auth:      &TunnelAuth{Basic: "alice:s3cret", AllowIPs: []string{"10.0.0.0/8"}},
+					Headers: &TunnelHeaders{Request: HeaderRuleSettings{
+						Set:    map[string]string{"X-Env": "dev"},
+						Remove: []string{"Cookie"},
+					}},
+				},
+				"api": {Upstream: "https://localhost:8443", MaxConcurrency: 4},
+			},
+		},
+		{name: "missing upstream", content: "tunnels:\n  web:\n    subdomain: app\n", wantErr: true},
+		{name: "empty tunnel", content: "tunnels:\n  web:\n", wantErr: true},
+		{name: "invalid YAML", content: "tunnels: [", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, TunnelsFileName)
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			file, err := LoadTunnels(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadTunnels error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(file.Tunnels, tt.want) {
+				t.Errorf("Tunnels = %+v, want %+v", file.Tunnels, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadTunnels(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("LoadTunnels of a missing file: want an error")
+	}
+}
+
+func TestTunnelsFileLookup(t *testing.T) {
+	file := &TunnelsFile{Tunnels: map[string]*TunnelSettings{
+		"web": {Upstream: "3000"},
+		"api": {Upstream: "4000"},
+	}}
+	if names := file.Names(); !reflect.DeepEqual(names, []string{"api", "web"}) {
+		t.Errorf("Names = %v", names)
+	}
+	if tunnel, err := file.Tunnel("web"); err != nil || tunnel.Upstream != "3000" {
+		t.Errorf("Tunnel(web) = (%+v, %v)", tunnel, err)
+	}
+	if _, err := file.Tunnel("db"); err == nil {
+		t.Error("Tunnel(db): want an error")
+	}
+}
+
+func TestFindTunnelsFile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+
+	if _, err := FindTunnelsFile(); err == nil {
+		t.Fatal("found a tunnels file where there is none")
+	}
+
+	configDir, err := GetConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(configDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	userFile := filepath.Join(configDir, TunnelsFileName)
+	if err := os.WriteFile(userFile, []byte("tunnels: {}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if path, err := FindTunnelsFile(); err != nil || path != userFile {
+		t.Errorf("FindTunnelsFile = (%q, %v), want %q", path, err, userFile)
+	}
+
+	// The current directory wins over the config directory.
+	if err := os.WriteFile(TunnelsFileName, []byte("tunnels: {}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if path, err := FindTunnelsFile(); err != nil || path != TunnelsFileName {
+		t.Errorf("FindTunnelsFile = (%q, %v), want %q", path, err, TunnelsFileName)
+	}
+}
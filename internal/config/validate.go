@@ -0,0 +1,189 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validate checks the client config, returning every problem found.
+func (c *ClientConfig) Validate() error {
+	var errs []error
+	if c.CurrentProfile != "" {
+		if _, ok := c.Profiles[c.CurrentProfile]; !ok {
+			errs = append(errs, fmt.Errorf("current_profile: profile %q does not exist", c.CurrentProfile))
+		}
+	}
+	for _, name := range c.ProfileNames() {
+		if err := c.Profiles[name].Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("profile %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks a profile. Either field may still be unset.
+func (p *Profile) Validate() error {
+	if p.TunnelServer == "" {
+		return nil
+	}
+	if err := ValidateServerURL(p.TunnelServer); err != nil {
+		return fmt.Errorf("tunnel_server: %w", err)
+	}
+	return nil
+}
+
+// ValidateServerURL checks a tunnel server URL such as
+// "https://tunnel.example.com".
+func ValidateServerURL(server string) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return fmt.Errorf("%q is not a valid URL: %w", server, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must start with http:// or https://", server)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", server)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return fmt.Errorf("%q must not have a path, the server is always reached at /ws", server)
+	}
+	return nil
+}
+
+// ValidatePort checks a TCP port number.
+func ValidatePort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%q is not a port number between 1 and 65535", port)
+	}
+	return nil
+}
+
+// ValidateDomain checks a bare domain name such as "tunnel.example.com".
+func ValidateDomain(domain string) error {
+	if strings.Contains(domain, "://") || strings.ContainsAny(domain, "/: ") {
+		return fmt.Errorf("%q must be a bare domain name, without scheme, port or path", domain)
+	}
+	return nil
+}
+
+// Validate checks the server config, returning every problem found. Unset
+// fields are not errors; the server falls back to flags, environment
+// variables or defaults for them.
+func (c *ServerConfig) Validate() error {
+	var errs []error
+	check := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		}
+	}
+
+	if c.ServerPort != "" {
+		check("server_port", ValidatePort(c.ServerPort))
+	}
+	if c.ServerDomain != "" {
+		check("server_domain", ValidateDomain(c.ServerDomain))
+	}
+	if c.ServerDrainTimeout != "" {
+		check("server_drain_timeout", validateDuration(c.ServerDrainTimeout))
+	}
+	check("server_trusted_proxies", validateCIDRs(c.ServerTrustedProxies))
+	check("server_allow_ips", validateCIDRs(c.ServerAllowIPs))
+	check("server_deny_ips", validateCIDRs(c.ServerDenyIPs))
+	if c.ServerRegistry != "" && c.ServerRegistry != "memory" &&
+		!strings.HasPrefix(c.ServerRegistry, "redis://") && !strings.HasPrefix(c.ServerRegistry, "rediss://") {
+		check("server_registry", fmt.Errorf("%q must be memory or a redis:// URL", c.ServerRegistry))
+	}
+	if (c.ServerTLSCert == "") != (c.ServerTLSKey == "") {
+		check("server_tls_cert", errors.New("server_tls_cert and server_tls_key must be set together"))
+	}
+
+	if log := c.ServerAccessLog; log != nil {
+		if log.Path == "" {
+			check("server_access_log.path", errors.New("is required"))
+		}
+		if format := strings.ToLower(log.Format); format != "" && format != "json" && format != "combined" {
+			check("server_access_log.format", fmt.Errorf("%q must be json or combined", log.Format))
+		}
+		if log.MaxSizeMB < 0 || log.MaxBackups < 0 {
+			check("server_access_log", errors.New("max_size_mb and max_backups must not be negative"))
+		}
+	}
+
+	if limits := c.ServerLimits; limits != nil {
+		check("server_limits.tunnel", limits.Tunnel.validate())
+		check("server_limits.token", limits.Token.validate())
+		check("server_limits.ip", limits.IP.validate())
+	}
+
+	names := make(map[string]bool)
+	for i, t := range c.ServerTokens {
+		field := fmt.Sprintf("server_tokens[%d]", i)
+		switch {
+		case t.Name == "" || t.Token == "":
+			check(field, errors.New("name and token are required"))
+		case t.Name == "default":
+			check(field, errors.New(`the name "default" is reserved for server_token`))
+		case names[t.Name]:
+			check(field, fmt.Errorf("duplicate name %q", t.Name))
+		}
+		if t.BytesPerDay < 0 || t.RequestsPerMonth < 0 || t.MaxTunnels < 0 {
+			check(field, errors.New("quotas must not be negative"))
+		}
+		names[t.Name] = true
+	}
+
+	if oidc := c.ServerOIDC; oidc != nil {
+		if oidc.IssuerURL != "" {
+			if u, err := url.Parse(oidc.IssuerURL); err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+				check("server_oidc.issuer_url", fmt.Errorf("%q is not an http(s) URL", oidc.IssuerURL))
+			}
+			if oidc.ClientID == "" {
+				check("server_oidc.client_id", errors.New("is required with issuer_url"))
+			}
+		}
+		if oidc.SessionTTL != "" {
+			check("server_oidc.session_ttl", validateDuration(oidc.SessionTTL))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (l LimitSettings) validate() error {
+	if l.RequestsPerSecond < 0 || l.Burst < 0 || l.MaxInFlight < 0 {
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+func validateDuration(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration such as 30s or 5m", value)
+	}
+	if d < 0 {
+		return fmt.Errorf("%q must not be negative", value)
+	}
+	return nil
+}
+
+// validateCIDRs checks a list of CIDRs or single IPs.
+func validateCIDRs(entries []string) error {
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if _, err := netip.ParsePrefix(entry); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(entry); err != nil {
+			return fmt.Errorf("%q is not a CIDR or IP address", entry)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateServerURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{url: "https://tunnel.example.com"},
+		{url: "http://localhost:9090/"},
+		{url: "tunnel.example.com", wantErr: true},
+		{url: "ws://tunnel.example.com", wantErr: true},
+		{url: "https://", wantErr: true},
+		{url: "https://tunnel.example.com/ws", wantErr: true},
+		{url: "https://exa mple.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := ValidateServerURL(tt.url); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateServerURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidatePortAndDomain(t *testing.T) {
+	for port, valid := range map[string]bool{"1": true, "9090": true, "65535": true, "0": false, "65536": false, "http": false, "": false} {
+		if err := ValidatePort(port); (err == nil) != valid {
+			t.Errorf("ValidatePort(%q) error = %v", port, err)
+		}
+	}
+	for domain, valid := range map[string]bool{"tunnel.example.com": true, "localhost": true, "https://example.com": false, "example.com:80": false, "example.com/x": false} {
+		if err := ValidateDomain(domain); (err == nil) != valid {
+			t.Errorf("ValidateDomain(%q) error = %v", domain, err)
+		}
+	}
+}
+
+func TestServerConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ServerConfig
+		want   []string // fields named in the error; none means valid
+	}{
+		{name: "empty", config: ServerConfig{}},
+		{
+			name: "valid",
+			config: ServerConfig{
+				ServerPort:         "9090",
+				ServerDomain:       "tunnel.example.com",
+				ServerDrainTimeout: "30s",
+				ServerAllowIPs:     []string{"10.0.0.0/8", "192.0.2.1"},
+				ServerRegistry:     "redis://localhost:6379/0",
+				ServerAccessLog:    &AccessLogSettings{Path: "/var/log/dr1ll.log", Format: "Combined"},
+				ServerTokens:       []TokenSettings{{Name: "ci", Token: "t"}},
+			},
+		},
+		{name: "bad port", config: ServerConfig{ServerPort: "99999"}, want: []string{"server_port"}},
+		{name: "bad domain", config: ServerConfig{ServerDomain: "https://x.com"}, want: []string{"server_domain"}},
+		{name: "bad drain timeout", config: ServerConfig{ServerDrainTimeout: "soon"}, want: []string{"server_drain_timeout"}},
+		{name: "bad CIDR", config: ServerConfig{ServerDenyIPs: []string{"10.0.0.0/40"}}, want: []string{"server_deny_ips"}},
+		{name: "bad registry", config: ServerConfig{ServerRegistry: "etcd://x"}, want: []string{"server_registry"}},
+		{name: "access log without path", config: ServerConfig{ServerAccessLog: &AccessLogSettings{}}, want: []string{"server_access_log.path"}},
+		{name: "bad access log format", config: ServerConfig{ServerAccessLog: &AccessLogSettings{Path: "a", Format: "xml"}}, want: []string{"server_access_log.format"}},
+		{
+			name:   "token problems",
+			config: ServerConfig{ServerTokens: []TokenSettings{{Name: "ci"}, {Name: "default", Token: "t"}, {Name: "a", Token: "t"}, {Name: "a", Token: "u"}}},
+			want:   []string{"server_tokens[0]", "server_tokens[1]", "server_tokens[3]"},
+		},
+		{
+			name:   "every problem is reported",
+			config: ServerConfig{ServerPort: "x", ServerDomain: "a/b", ServerAllowIPs: []string{"nope"}},
+			want:   []string{"server_port", "server_domain", "server_allow_ips"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate = nil, want errors for %v", tt.want)
+			}
+			for _, field := range tt.want {
+				if !strings.Contains(err.Error(), field+":") {
+					t.Errorf("error %q does not mention %s", err, field)
+				}
+			}
+		})
+	}
+}
+
+func TestClientConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ClientConfig
+		wantErr bool
+	}{
+		{name: "empty", config: ClientConfig{}},
+		{
+			name: "valid",
+			config: ClientConfig{CurrentProfile: "work", Profiles: map[string]*Profile{
+				"work": {TunnelServer: "https://tunnel.example.com"},
+			}},
+		},
+		{name: "missing current profile", config: ClientConfig{CurrentProfile: "work"}, wantErr: true},
+		{
+			name: "bad server",
+			config: ClientConfig{Profiles: map[string]*Profile{
+				"work": {TunnelServer: "tunnel.example.com"},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Package httpheader holds header handling shared by the tunnel server and
+// client, which both act as one half of a reverse proxy.
+package httpheader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// hopByHop lists the headers that apply to a single transport-level
+// connection and must not be forwarded by proxies (RFC 7230, section 6.1).
+var hopByHop = []string{
+	"Connection",
+	"Proxy-Connection", // non-standard but still sent by some clients
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer", // not Trailers, which is a value of Te
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RemoveHopByHop deletes hop-by-hop headers from h, including any header
+// named in Connection. "Te: trailers" is kept so upstreams still know the
+// caller accepts trailers.
+func RemoveHopByHop(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+
+	acceptsTrailers := false
+	for _, value := range h.Values("Te") {
+		for _, coding := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(coding), "trailers") {
+				acceptsTrailers = true
+			}
+		}
+	}
+
+	for _, name := range hopByHop {
+		h.Del(name)
+	}
+	if acceptsTrailers {
+		h.Set("Te", "trailers")
+	}
+}
+
+// Map is the header form carried by tunnel messages, keyed by canonical
+// header name. A header with one value is encoded as a JSON string and
+// one with several, such as Set-Cookie, as a list. Versions before lists
+// were introduced only decode the string form and reject a message with
+// a multi-value header.
+type Map map[string][]string
+
+// Get returns the first value of the named header.
+func (m Map) Get(name string) string {
+	if values := m[http.CanonicalHeaderKey(name)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Set replaces the values of the named header with value.
+func (m Map) Set(name, value string) {
+	m[http.CanonicalHeaderKey(name)] = []string{value}
+}
+
+func (m Map) MarshalJSON() ([]byte, error) {
+	out := make(map[string]any, len(m))
+	for name, values := range m {
+		if len(values) == 1 {
+			out[name] = values[0]
+		} else {
+			out[name] = values
+		}
+	}
+	return json.Marshal(out)
+}
+
+func (m *Map) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*m = make(Map, len(raw))
+	for name, value := range raw {
+		var single string
+		if err := json.Unmarshal(value, &single); err == nil {
+			(*m)[name] = []string{single}
+			continue
+		}
+		var values []string
+		if err := json.Unmarshal(value, &values); err != nil {
+			return fmt.Errorf("header %s: %w", name, err)
+		}
+		(*m)[name] = values
+	}
+	return nil
+}
+
+// ToMap converts h into the form carried by tunnel messages, keeping every
+// value.
+func ToMap(h http.Header) Map {
+	m := make(Map, len(h))
+	for name, values := range h {
+		if len(values) > 0 {
+			m[name] = append([]string(nil), values...)
+		}
+	}
+	return m
+}
+
+// FromMap converts a tunnel message header map back into an http.Header.
+func FromMap(m Map) http.Header {
+	h := make(http.Header, len(m))
+	for name, values := range m {
+		for _, value := range values {
+			h.Add(name, value)
+		}
+	}
+	return h
+}
@@ -0,0 +1,115 @@
+package httpheader
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRemoveHopByHop(t *testing.T) {
+	tests := []struct {
+		name string
+		in   http.Header
+		want http.Header
+	}{
+		{
+			name: "standard hop-by-hop headers",
+			in: http.Header{
+				"Connection":          {"keep-alive"},
+				"Keep-Alive":          {"timeout=5"},
+				"Proxy-Authorization": {"Basic Zm9vOmJhcg=="},
+				"Transfer-Encoding":   {"chunked"},
+				"Upgrade":             {"h2c"},
+				"Trailer":             {"Expires"},
+				"Content-Type":        {"text/plain"},
+			},
+			want: http.Header{"Content-Type": {"text/plain"}},
+		},
+		{
+			name: "headers named in Connection",
+			in: http.Header{
+				"Connection": {"X-Secret, close", "X-Other"},
+				"X-Secret":   {"1"},
+				"X-Other":    {"2"},
+				"X-Kept":     {"3"},
+			},
+			want: http.Header{"X-Kept": {"3"}},
+		},
+		{
+			name: "Te trailers is kept",
+			in:   http.Header{"Te": {"gzip, Trailers"}},
+			want: http.Header{"Te": {"trailers"}},
+		},
+		{
+			name: "Te without trailers is dropped",
+			in:   http.Header{"Te": {"gzip"}},
+			want: http.Header{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RemoveHopByHop(tt.in)
+			if !reflect.DeepEqual(tt.in, tt.want) {
+				t.Errorf("got %v, want %v", tt.in, tt.want)
+			}
+		})
+	}
+}
+
+func TestMapJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Map
+		json string
+	}{
+		{name: "single value is a string", m: Map{"Content-Type": {"text/plain"}}, json: `{"Content-Type":"text/plain"}`},
+		{name: "several values are a list", m: Map{"Set-Cookie": {"a=1", "b=2"}}, json: `{"Set-Cookie":["a=1","b=2"]}`},
+		{name: "empty", m: Map{}, json: `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.json {
+				t.Errorf("Marshal = %s, want %s", data, tt.json)
+			}
+
+			var got Map
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.m) {
+				t.Errorf("Unmarshal = %v, want %v", got, tt.m)
+			}
+		})
+	}
+}
+
+func TestMapUnmarshalInvalid(t *testing.T) {
+	var m Map
+	if err := json.Unmarshal([]byte(`{"X-Count":1}`), &m); err == nil {
+		t.Error("expected an error for a numeric header value")
+	}
+}
+
+func TestMapRoundTrip(t *testing.T) {
+	h := http.Header{
+		"Set-Cookie":   {"a=1", "b=2"},
+		"Content-Type": {"text/plain"},
+	}
+	m := ToMap(h)
+	if got := m.Get("content-type"); got != "text/plain" {
+		t.Errorf("Get = %q, want %q", got, "text/plain")
+	}
+	if got := FromMap(m); !reflect.DeepEqual(got, h) {
+		t.Errorf("FromMap(ToMap(h)) = %v, want %v", got, h)
+	}
+
+	m["Set-Cookie"][0] = "changed"
+	if h.Get("Set-Cookie") != "a=1" {
+		t.Error("ToMap shares value slices with the header")
+	}
+}
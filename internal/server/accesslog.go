@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogConfig configures the per-request access log.
+type AccessLogConfig struct {
+	Path string
+	// Format is "json" (the default) or "combined", which is the Combined
+	// Log Format prefixed with the tunnel host and followed by the duration
+	// in milliseconds.
+	Format     string
+	MaxSizeMB  int // rotate when the file exceeds this size; 0 disables rotation
+	MaxBackups int // rotated files to keep; defaults to 5
+}
+
+// accessEntry is one tunneled request as written to the access log.
+type accessEntry struct {
+	Time      time.Time `json:"time"`
+	Host      string    `json:"host"`
+	Tunnel    string    `json:"tunnel"`
+	ClientIP  string    `json:"client_ip"`
+	User      string    `json:"user,omitempty"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int       `json:"bytes"`
+	Duration  float64   `json:"duration_ms"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+type accessLog struct {
+	mu     sync.Mutex
+	out    io.WriteCloser
+	format string
+}
+
+func newAccessLog(cfg AccessLogConfig) (*accessLog, error) {
+	format := strings.ToLower(cfg.Format)
+	switch format {
+	case "":
+		format = "json"
+	case "json", "combined":
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", cfg.Format)
+	}
+
+	out, err := openRotatingFile(cfg.Path, int64(cfg.MaxSizeMB)*1024*1024, cfg.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return &accessLog{out: out, format: format}, nil
+}
+
+func (l *accessLog) write(e accessEntry) {
+	var line []byte
+	if l.format == "combined" {
+		line = []byte(e.combined())
+	} else {
+		line, _ = json.Marshal(e)
+		line = append(line, '\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line)
+}
+
+func (l *accessLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.out.Close()
+}
+
+func (e accessEntry) combined() string {
+	return fmt.Sprintf("%s %s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\" %.3f\n",
+		e.Host,
+		e.ClientIP,
+		dashIfEmpty(e.User),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, e.URI, e.Proto,
+		e.Status,
+		dashIfEmpty(fmt.Sprint(e.Bytes)),
+		quoteLogValue(dashIfEmpty(e.Referer)),
+		quoteLogValue(dashIfEmpty(e.UserAgent)),
+		e.Duration,
+	)
+}
+
+func dashIfEmpty(v string) string {
+	if v == "" || v == "0" {
+		return "-"
+	}
+	return v
+}
+
+// quoteLogValue escapes characters that would break a quoted log field.
+func quoteLogValue(v string) string {
+	return strings.NewReplacer(`"`, `\"`, "\\", `\\`, "\n", `\n`).Replace(v)
+}
+
+// rotatingFile is an append-only file that is renamed to path.1, path.2, ...
+// once it grows past maxSize.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	if path == "" {
+		return nil, fmt.Errorf("access log path is required")
+	}
+	if maxBackups <= 0 {
+		maxBackups = 5
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open access log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.maxSize > 0 && f.size+int64(len(p)) > f.maxSize && f.size > 0 {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+
+	os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate access log: %w", err)
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	return f.file.Close()
+}
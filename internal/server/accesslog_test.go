@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEntry = accessEntry{
+	Time:      time.Date(2024, 3, 15, 12, 30, 45, 0, time.UTC),
+	Host:      "app.example.com",
+	Tunnel:    "app",
+	ClientIP:  "203.0.113.9",
+	User:      "alice",
+	Method:    "GET",
+	URI:       "/search?q=1",
+	Proto:     "HTTP/1.1",
+	Status:    200,
+	Bytes:     512,
+	Duration:  12.5,
+	UserAgent: `curl/8 "quoted"`,
+	RequestID: "req-1",
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: "combined",
+			want:   `app.example.com 203.0.113.9 - alice [15/Mar/2024:12:30:45 +0000] "GET /search?q=1 HTTP/1.1" 200 512 "-" "curl/8 \"quoted\"" 12.500` + "\n",
+		},
+		{
+			format: "",
+			want:   `{"time":"2024-03-15T12:30:45Z","host":"app.example.com","tunnel":"app","client_ip":"203.0.113.9","user":"alice","method":"GET","uri":"/search?q=1","proto":"HTTP/1.1","status":200,"bytes":512,"duration_ms":12.5,"user_agent":"curl/8 \"quoted\"","request_id":"req-1"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "access.log")
+			l, err := newAccessLog(AccessLogConfig{Path: path, Format: tt.format})
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.write(testEntry)
+			l.Close()
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("got  %s\nwant %s", data, tt.want)
+			}
+			if tt.format == "" && !json.Valid(data) {
+				t.Error("JSON line is not valid JSON")
+			}
+		})
+	}
+}
+
+func TestAccessLogConfigErrors(t *testing.T) {
+	if _, err := newAccessLog(AccessLogConfig{Path: filepath.Join(t.TempDir(), "a.log"), Format: "xml"}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if _, err := newAccessLog(AccessLogConfig{}); err == nil {
+		t.Error("expected an error for a missing path")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "access.log")
+	f, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 4 {
+		if _, err := fmt.Fprintf(f, "line %d\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	want := map[string]string{
+		path:        "line 3\n",
+		path + ".1": "line 2\n",
+		path + ".2": "line 1\n",
+	}
+	for name, content := range want {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), data, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("kept more backups than configured")
+	}
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	for _, line := range []string{"one\n", "two\n"} {
+		f, err := openRotatingFile(path, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(line))
+		f.Close()
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(data), "one\ntwo\n") {
+		t.Errorf("reopening truncated the log: %q", data)
+	}
+}
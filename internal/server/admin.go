@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TunnelInfo describes a connected tunnel in the admin API.
+type TunnelInfo struct {
+	Subdomain   string      `json:"subdomain"`
+	URL         string      `json:"url"`
+	Token       string      `json:"token"`
+	RemoteAddr  string      `json:"remote_addr"`
+	ConnectedAt time.Time   `json:"connected_at"`
+	Stats       TunnelStats `json:"stats"`
+}
+
+// TunnelStats are the traffic counters of a connected tunnel.
+type TunnelStats struct {
+	Requests int64 `json:"requests"`
+	InFlight int64 `json:"in_flight"`
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+	Errors   int64 `json:"errors"`
+}
+
+// tunnelStats is the live, concurrently updated form of TunnelStats.
+type tunnelStats struct {
+	requests atomic.Int64
+	inFlight atomic.Int64
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+	errors   atomic.Int64
+}
+
+func (ts *tunnelStats) snapshot() TunnelStats {
+	return TunnelStats{
+		Requests: ts.requests.Load(),
+		InFlight: ts.inFlight.Load(),
+		BytesIn:  ts.bytesIn.Load(),
+		BytesOut: ts.bytesOut.Load(),
+		Errors:   ts.errors.Load(),
+	}
+}
+
+// adminHandler serves /metrics and, when an admin token is configured, the
+// tunnel management API.
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics.handler())
+
+	if s.adminToken != "" {
+		mux.HandleFunc("GET /api/tunnels", s.requireAdmin(s.handleListTunnels))
+		mux.HandleFunc("GET /api/tunnels/{subdomain}/stats", s.requireAdmin(s.handleTunnelStats))
+		mux.HandleFunc("DELETE /api/tunnels/{subdomain}", s.requireAdmin(s.handleKillTunnel))
+	}
+
+	return mux
+}
+
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !found || scheme != "Bearer" || !secureEqual(token, s.adminToken) {
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleListTunnels(w http.ResponseWriter, r *http.Request) {
+	s.mutex.RLock()
+	tunnels := make([]TunnelInfo, 0, len(s.clients))
+	for _, client := range s.clients {
+		tunnels = append(tunnels, s.tunnelInfo(client))
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].Subdomain < tunnels[j].Subdomain
+	})
+	writeJSON(w, http.StatusOK, tunnels)
+}
+
+func (s *Server) handleTunnelStats(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.getClient(r.PathValue("subdomain"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "tunnel not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.tunnelInfo(client))
+}
+
+func (s *Server) handleKillTunnel(w http.ResponseWriter, r *http.Request) {
+	subdomain := r.PathValue("subdomain")
+	if !s.disconnect(subdomain, "disconnected by administrator") {
+		writeJSONError(w, http.StatusNotFound, "tunnel not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) tunnelInfo(client *Client) TunnelInfo {
+	return TunnelInfo{
+		Subdomain:   client.subdomain,
+		URL:         fmt.Sprintf("%s.%s", client.subdomain, s.domain),
+		Token:       client.tokenName,
+		RemoteAddr:  client.remoteAddr,
+		ConnectedAt: client.connectedAt,
+		Stats:       client.stats.snapshot(),
+	}
+}
+
+// disconnect closes a tunnel's connection with reason, which unregisters it
+// once its read loop exits.
+func (s *Server) disconnect(subdomain, reason string) bool {
+	client, ok := s.getClient(subdomain)
+	if !ok {
+		return false
+	}
+
+	// WriteControl may run concurrently with the write pump.
+	closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	client.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+	client.conn.Close()
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Handshake headers a client uses to protect its public URL.
+const (
+	basicAuthHeader  = "X-Dr1ll-Auth-Basic"  // base64("user:pass")
+	bearerAuthHeader = "X-Dr1ll-Auth-Bearer" // bearer token
+)
+
+// tunnelAuth holds the credentials public callers must present before a
+// request is sent down a tunnel. Either scheme is accepted when both are set.
+type tunnelAuth struct {
+	username string
+	password string
+	bearer   string
+}
+
+// parseTunnelAuth reads the credentials registered in the WebSocket
+// handshake. It returns nil when the tunnel is public.
+func parseTunnelAuth(r *http.Request) (*tunnelAuth, error) {
+	auth := &tunnelAuth{
+		bearer: r.Header.Get(bearerAuthHeader),
+	}
+
+	if encoded := r.Header.Get(basicAuthHeader); encoded != "" {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("invalid basic auth credentials")
+		}
+		username, password, ok := strings.Cut(string(decoded), ":")
+		if !ok || username == "" {
+			return nil, errors.New("basic auth credentials must be user:pass")
+		}
+		auth.username = username
+		auth.password = password
+	}
+
+	if auth.username == "" && auth.bearer == "" {
+		return nil, nil
+	}
+	return auth, nil
+}
+
+// check verifies the Authorization header of a public request. The returned
+// user is empty for bearer tokens, which carry no identity.
+func (a *tunnelAuth) check(r *http.Request) (user string, ok bool) {
+	if a.username != "" {
+		if username, password, ok := r.BasicAuth(); ok &&
+			secureEqual(username, a.username) && secureEqual(password, a.password) {
+			return username, true
+		}
+	}
+
+	if a.bearer != "" {
+		scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+		if found && strings.EqualFold(scheme, "Bearer") && secureEqual(token, a.bearer) {
+			return "", true
+		}
+	}
+
+	return "", false
+}
+
+// challenge writes a 401 advertising the schemes the tunnel accepts.
+func (a *tunnelAuth) challenge(w http.ResponseWriter) {
+	if a.username != "" {
+		w.Header().Add("WWW-Authenticate", `Basic realm="dr1ll", charset="UTF-8"`)
+	}
+	if a.bearer != "" {
+		w.Header().Add("WWW-Authenticate", `Bearer realm="dr1ll"`)
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestParseTunnelAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		basic   string
+		bearer  string
+		want    *tunnelAuth
+		wantErr bool
+	}{
+		{name: "public", want: nil},
+		{name: "basic", basic: "alice:s3cret", want: &tunnelAuth{username: "alice", password: "s3cret"}},
+		{name: "password with colon", basic: "alice:a:b", want: &tunnelAuth{username: "alice", password: "a:b"}},
+		{name: "empty password", basic: "alice:", want: &tunnelAuth{username: "alice"}},
+		{name: "bearer", bearer: "tok", want: &tunnelAuth{bearer: "tok"}},
+		{name: "both", basic: "alice:s3cret", bearer: "tok", want: &tunnelAuth{username: "alice", password: "s3cret", bearer: "tok"}},
+		{name: "missing colon", basic: "alice", wantErr: true},
+		{name: "empty user", basic: ":s3cret", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.basic != "" {
+				r.Header.Set(basicAuthHeader, base64.StdEncoding.EncodeToString([]byte(tt.basic)))
+			}
+			if tt.bearer != "" {
+				r.Header.Set(bearerAuthHeader, tt.bearer)
+			}
+
+			got, err := parseTunnelAuth(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want == nil || got == nil {
+				if got != tt.want {
+					t.Errorf("got %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+			if *got != *tt.want {
+				t.Errorf("got %+v, want %+v", *got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTunnelAuthInvalidBase64(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(basicAuthHeader, "not base64!")
+	if _, err := parseTunnelAuth(r); err == nil {
+		t.Error("expected an error for invalid base64")
+	}
+}
+
+func TestTunnelAuthCheck(t *testing.T) {
+	both := &tunnelAuth{username: "alice", password: "s3cret", bearer: "tok"}
+	basicOnly := &tunnelAuth{username: "alice", password: "s3cret"}
+	bearerOnly := &tunnelAuth{bearer: "tok"}
+
+	basic := func(user, pass string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+	}
+	tests := []struct {
+		name          string
+		auth          *tunnelAuth
+		authorization string
+		wantUser      string
+		wantOK        bool
+	}{
+		{name: "no credentials", auth: both},
+		{name: "basic ok", auth: both, authorization: basic("alice", "s3cret"), wantUser: "alice", wantOK: true},
+		{name: "basic wrong password", auth: both, authorization: basic("alice", "nope")},
+		{name: "basic wrong user", auth: both, authorization: basic("bob", "s3cret")},
+		{name: "bearer ok", auth: both, authorization: "Bearer tok", wantOK: true},
+		{name: "bearer scheme is case-insensitive", auth: both, authorization: "bearer tok", wantOK: true},
+		{name: "bearer wrong token", auth: both, authorization: "Bearer tok2"},
+		{name: "bearer against basic-only", auth: basicOnly, authorization: "Bearer tok"},
+		{name: "basic against bearer-only", auth: bearerOnly, authorization: basic("alice", "s3cret")},
+		{name: "bearer without scheme", auth: bearerOnly, authorization: "tok"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			user, ok := tt.auth.check(r)
+			if user != tt.wantUser || ok != tt.wantOK {
+				t.Errorf("check = (%q, %v), want (%q, %v)", user, ok, tt.wantUser, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTunnelAuthChallenge(t *testing.T) {
+	tests := []struct {
+		name string
+		auth *tunnelAuth
+		want []string
+	}{
+		{name: "basic", auth: &tunnelAuth{username: "alice"}, want: []string{`Basic realm="dr1ll", charset="UTF-8"`}},
+		{name: "bearer", auth: &tunnelAuth{bearer: "tok"}, want: []string{`Bearer realm="dr1ll"`}},
+		{name: "both", auth: &tunnelAuth{username: "alice", bearer: "tok"}, want: []string{`Basic realm="dr1ll", charset="UTF-8"`, `Bearer realm="dr1ll"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.auth.challenge(w)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+			if got := w.Header().Values("WWW-Authenticate"); !slices.Equal(got, tt.want) {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
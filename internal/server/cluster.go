@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/M1z23R/dr1ll/internal/httpheader"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// nodeSecretHeader authenticates a public request forwarded from another
+// node of the cluster. It is never passed on to tunnels.
+const nodeSecretHeader = "X-Dr1ll-Node-Secret"
+
+// peerServerNameKey carries the host a forwarded request is for to the
+// dialer of peerTransport.
+type peerServerNameKey struct{}
+
+// peerTransport forwards requests to other nodes. Over TLS it verifies the
+// peer's certificate for the tunnel host being forwarded, which every node
+// serves, rather than for the address the peer is dialed at.
+var peerTransport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		serverName, _ := ctx.Value(peerServerNameKey{}).(string)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+	return t
+}()
+
+// nodeURL identifies this node in the registry: its address with the
+// scheme its public listener speaks, so peers forward over TLS when it is
+// enabled.
+func (s *Server) nodeURL() string {
+	if s.certificate.Load() != nil {
+		return "https://" + s.nodeAddr
+	}
+	return "http://" + s.nodeAddr
+}
+
+// ownerURL parses a node as recorded in the registry. Nodes recorded as a
+// bare host:port speak plain HTTP.
+func ownerURL(owner string) *url.URL {
+	if u, err := url.Parse(owner); err == nil && u.Host != "" && (u.Scheme == "http" || u.Scheme == "https") {
+		return &url.URL{Scheme: u.Scheme, Host: u.Host}
+	}
+	return &url.URL{Scheme: "http", Host: owner}
+}
+
+// fromPeerNode reports whether r was forwarded by another cluster node.
+func (s *Server) fromPeerNode(r *http.Request) bool {
+	return s.clusterSecret != "" && secureEqual(r.Header.Get(nodeSecretHeader), s.clusterSecret)
+}
+
+// claimSubdomain reserves subdomain for a tunnel of tokenName on this node,
+// checking local tunnels, stored reservations and the registry. The local
+// reservation is taken first and held until registerClient or
+// releaseSubdomain, so concurrent handshakes cannot claim it twice.
+func (s *Server) claimSubdomain(subdomain, tokenName string) (claimed bool, err error) {
+	if !s.reserveSubdomain(subdomain) {
+		return false, nil
+	}
+	defer func() {
+		if !claimed {
+			s.unreserveSubdomain(subdomain)
+		}
+	}()
+
+	if s.store != nil {
+		holder, err := s.store.reservedBy(subdomain)
+		if err != nil {
+			return false, err
+		}
+		if holder != "" && holder != tokenName {
+			return false, nil
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), registryTimeout)
+	defer cancel()
+	return s.registry.Claim(ctx, subdomain, s.nodeURL(), claimTTL)
+}
+
+func (s *Server) releaseSubdomain(subdomain string) {
+	s.unreserveSubdomain(subdomain)
+
+	ctx, cancel := context.WithTimeout(context.Background(), registryTimeout)
+	defer cancel()
+	if err := s.registry.Release(ctx, subdomain, s.nodeURL()); err != nil {
+		slog.Warn("failed to release tunnel claim", "tunnel", subdomain, "error", err)
+	}
+}
+
+// forwardToOwner proxies a public request for a tunnel held by another node
+// to that node. It returns false if no other node owns the subdomain, in
+// which case nothing has been written.
+func (s *Server) forwardToOwner(ctx context.Context, w http.ResponseWriter, r *http.Request, subdomain string, fwd forwardedInfo) bool {
+	// A forwarded request is never forwarded again, so stale registry
+	// entries cannot make nodes bounce it between each other.
+	if s.clusterSecret == "" || s.fromPeerNode(r) {
+		return false
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, registryTimeout)
+	owner, err := s.registry.Owner(lookupCtx, subdomain)
+	cancel()
+	if err != nil {
+		slog.Warn("failed to look up tunnel owner", "tunnel", subdomain, "error", err)
+		return false
+	}
+	if owner == "" || owner == s.nodeURL() {
+		return false
+	}
+
+	target := ownerURL(owner)
+	serverName := r.Host
+	if host, _, err := net.SplitHostPort(serverName); err == nil {
+		serverName = host
+	}
+	ctx = context.WithValue(ctx, peerServerNameKey{}, serverName)
+	proxy := &httputil.ReverseProxy{
+		Transport: peerTransport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.Host = pr.In.Host
+
+			// The owner trusts this hop, so hand it the client as seen here.
+			headers := make(httpheader.Map)
+			fwd.apply(headers)
+			for name, values := range headers {
+				pr.Out.Header[name] = values
+			}
+			pr.Out.Header.Set(nodeSecretHeader, s.clusterSecret)
+			otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(pr.Out.Header))
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			slog.Warn("failed to forward request to tunnel owner", "tunnel", subdomain, "node", owner, "error", err)
+			http.Error(w, "Tunnel node unavailable", http.StatusBadGateway)
+		},
+	}
+
+	slog.Debug("forwarding request to tunnel owner", "tunnel", subdomain, "node", owner)
+	proxy.ServeHTTP(w, r.WithContext(ctx))
+	return true
+}
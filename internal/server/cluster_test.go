@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// clusterNode returns a node of a cluster sharing registry.
+func clusterNode(t *testing.T, registry Registry, secret string) *Server {
+	t.Helper()
+	s := NewServer("token", "example.com", "0")
+	s.SetRegistry(registry)
+	s.SetNodeAddr("127.0.0.1:1")
+	s.SetClusterSecret(secret)
+	t.Cleanup(s.cancel)
+	return s
+}
+
+func TestForwardToOwner(t *testing.T) {
+	var got *http.Request
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		io.WriteString(w, "from peer")
+	}))
+	defer peer.Close()
+
+	registry := NewMemoryRegistry()
+	ctx := context.Background()
+	registry.Claim(ctx, "remote", peer.URL, claimTTL)
+	registry.Claim(ctx, "local", "http://127.0.0.1:1", claimTTL)
+
+	tests := []struct {
+		name      string
+		secret    string
+		host      string
+		header    map[string]string
+		forwarded bool
+	}{
+		{name: "owned by a peer", secret: "s3cret", host: "remote.example.com", forwarded: true},
+		{name: "wrong node secret", secret: "s3cret", host: "remote.example.com", header: map[string]string{nodeSecretHeader: "guess"}, forwarded: true},
+		{name: "already forwarded", secret: "s3cret", host: "remote.example.com", header: map[string]string{nodeSecretHeader: "s3cret"}},
+		{name: "owned by this node", secret: "s3cret", host: "local.example.com"},
+		{name: "unclaimed", secret: "s3cret", host: "nobody.example.com"},
+		{name: "no cluster secret", host: "remote.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			s := clusterNode(t, registry, tt.secret)
+
+			req := httptest.NewRequest("GET", "http://"+tt.host+"/path?q=1", nil)
+			req.RemoteAddr = "203.0.113.9:4000"
+			req.Header.Set("X-Forwarded-For", "198.51.100.1")
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, req)
+
+			if !tt.forwarded {
+				if got != nil || rec.Code != http.StatusNotFound {
+					t.Fatalf("status = %d, forwarded = %v; want 404 served locally", rec.Code, got != nil)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("request not forwarded, status = %d", rec.Code)
+			}
+			if rec.Code != http.StatusOK || rec.Body.String() != "from peer" {
+				t.Errorf("response = %d %q", rec.Code, rec.Body)
+			}
+			if got.Host != tt.host || got.URL.RequestURI() != "/path?q=1" {
+				t.Errorf("peer got %s%s", got.Host, got.URL.RequestURI())
+			}
+			if secret := got.Header.Get(nodeSecretHeader); secret != tt.secret {
+				t.Errorf("%s = %q, want %q", nodeSecretHeader, secret, tt.secret)
+			}
+			// The spoofed address is replaced by the client as seen here.
+			if xff := got.Header.Get("X-Forwarded-For"); xff != "203.0.113.9" {
+				t.Errorf("X-Forwarded-For = %q, want 203.0.113.9", xff)
+			}
+		})
+	}
+}
+
+func TestForwardToOwnerTLS(t *testing.T) {
+	serverNames := make(chan string, 1)
+	peer := httptest.NewUnstartedServer(http.NotFoundHandler())
+	peer.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverNames <- hello.ServerName
+			return nil, nil
+		},
+	}
+	peer.StartTLS()
+	defer peer.Close()
+	if !strings.HasPrefix(peer.URL, "https://") {
+		t.Fatalf("peer URL = %q", peer.URL)
+	}
+
+	registry := NewMemoryRegistry()
+	registry.Claim(context.Background(), "remote", peer.URL, claimTTL)
+	s := clusterNode(t, registry, "s3cret")
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "http://remote.example.com/", nil))
+
+	// The peer's test certificate is not trusted, but the handshake shows
+	// the request went over TLS and verified the tunnel host.
+	select {
+	case name := <-serverNames:
+		if name != "remote.example.com" {
+			t.Errorf("server name = %q, want remote.example.com", name)
+		}
+	default:
+		t.Fatal("request to an https node was not sent over TLS")
+	}
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d for an untrusted certificate", rec.Code, http.StatusBadGateway)
+	}
+}
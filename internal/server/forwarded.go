@@ -0,0 +1,169 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/M1z23R/dr1ll/internal/httpheader"
+)
+
+// forwardedInfo describes where a public request really came from, after
+// taking trusted proxies in front of the server into account.
+type forwardedInfo struct {
+	clientIP  string   // originating client address
+	chain     []string // X-Forwarded-For chain, ending with our direct peer
+	forwarded []string // Forwarded elements, ending with our own
+	host      string
+	proto     string
+}
+
+// parsePrefixes turns a list of CIDRs or bare IPs into prefixes.
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP %q: %w", entry, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// containsIP reports whether ip falls within any of prefixes.
+func containsIP(prefixes []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) isTrustedProxy(ip string) bool {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return containsIP(s.trustedProxies, ip)
+}
+
+// forwardedFor works out the original client, host and scheme of r. Incoming
+// X-Forwarded-* and Forwarded headers are only honored when the direct peer is
+// a trusted proxy or another cluster node; otherwise they are discarded as
+// spoofable.
+func (s *Server) forwardedFor(r *http.Request) forwardedInfo {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		peer = host
+	}
+
+	info := forwardedInfo{
+		clientIP: peer,
+		host:     r.Host,
+		proto:    "http",
+	}
+	if r.TLS != nil {
+		info.proto = "https"
+	}
+
+	fromNode := s.fromPeerNode(r)
+	if fromNode || s.isTrustedProxy(peer) {
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(value, ",") {
+				if hop = strings.TrimSpace(hop); hop != "" {
+					info.chain = append(info.chain, hop)
+				}
+			}
+		}
+		for _, value := range r.Header.Values("Forwarded") {
+			for _, element := range strings.Split(value, ",") {
+				if element = strings.TrimSpace(element); element != "" {
+					info.forwarded = append(info.forwarded, element)
+				}
+			}
+		}
+		if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+			info.host = strings.TrimSpace(strings.Split(host, ",")[0])
+		}
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+			info.proto = strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+		}
+	}
+	info.chain = append(info.chain, peer)
+
+	// The client is the right-most address that is not one of our proxies,
+	// skipping the forwarding node itself.
+	hops := info.chain
+	if fromNode && len(hops) > 1 {
+		hops = hops[:len(hops)-1]
+	}
+	info.clientIP = hops[0]
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !s.isTrustedProxy(hops[i]) {
+			info.clientIP = hops[i]
+			break
+		}
+	}
+
+	info.forwarded = append(info.forwarded, fmt.Sprintf("for=%s;host=%s;proto=%s",
+		forwardedNode(peer), forwardedValue(info.host), info.proto))
+
+	return info
+}
+
+// apply replaces any forwarding headers in headers with the computed ones.
+// The identity headers are only ever set by the server, so caller-supplied
+// ones are dropped too.
+func (f forwardedInfo) apply(headers httpheader.Map) {
+	for name := range headers {
+		switch http.CanonicalHeaderKey(name) {
+		case "Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto",
+			"X-Forwarded-User", "X-Forwarded-Email", "X-Forwarded-Groups":
+			delete(headers, name)
+		}
+	}
+
+	headers.Set("X-Forwarded-For", strings.Join(f.chain, ", "))
+	headers.Set("X-Forwarded-Host", f.host)
+	headers.Set("X-Forwarded-Proto", f.proto)
+	headers.Set("Forwarded", strings.Join(f.forwarded, ", "))
+}
+
+// forwardedNode formats an IP as an RFC 7239 node, quoting IPv6 addresses.
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return fmt.Sprintf("\"[%s]\"", ip)
+	}
+	return ip
+}
+
+// forwardedValue quotes v when it is not a valid RFC 7239 token.
+func forwardedValue(v string) string {
+	for _, c := range v {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return fmt.Sprintf("%q", v)
+		}
+	}
+	return v
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/M1z23R/dr1ll/internal/httpheader"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", entries: nil, want: nil},
+		{name: "cidr is masked", entries: []string{"10.1.2.3/8"}, want: []string{"10.0.0.0/8"}},
+		{name: "bare IPv4", entries: []string{" 192.0.2.1 "}, want: []string{"192.0.2.1/32"}},
+		{name: "bare IPv6", entries: []string{"2001:db8::1"}, want: []string{"2001:db8::1/128"}},
+		{name: "mapped IPv4 is unmapped", entries: []string{"::ffff:192.0.2.1"}, want: []string{"192.0.2.1/32"}},
+		{name: "blank entries skipped", entries: []string{"", "  "}, want: nil},
+		{name: "invalid CIDR", entries: []string{"10.0.0.0/33"}, wantErr: true},
+		{name: "invalid IP", entries: []string{"not-an-ip"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefixes, err := parsePrefixes(tt.entries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePrefixes(%q) error = %v, wantErr %v", tt.entries, err, tt.wantErr)
+			}
+			var got []string
+			for _, p := range prefixes {
+				got = append(got, p.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parsePrefixes(%q) = %q, want %q", tt.entries, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	tests := []struct {
+		name      string
+		trusted   []string
+		remote    string
+		tls       bool
+		headers   map[string]string
+		clientIP  string
+		chain     string
+		host      string
+		proto     string
+		forwarded string
+	}{
+		{
+			name:      "direct client",
+			remote:    "198.51.100.7:4321",
+			clientIP:  "198.51.100.7",
+			chain:     "198.51.100.7",
+			host:      "app.example.com",
+			proto:     "http",
+			forwarded: "for=198.51.100.7;host=app.example.com;proto=http",
+		},
+		{
+			name:      "direct TLS client",
+			remote:    "198.51.100.7:4321",
+			tls:       true,
+			clientIP:  "198.51.100.7",
+			chain:     "198.51.100.7",
+			host:      "app.example.com",
+			proto:     "https",
+			forwarded: "for=198.51.100.7;host=app.example.com;proto=https",
+		},
+		{
+			name:   "untrusted peer headers are discarded",
+			remote: "198.51.100.7:4321",
+			headers: map[string]string{
+				"X-Forwarded-For":   "203.0.113.9",
+				"X-Forwarded-Host":  "evil.example.com",
+				"X-Forwarded-Proto": "https",
+				"Forwarded":         "for=203.0.113.9",
+			},
+			clientIP:  "198.51.100.7",
+			chain:     "198.51.100.7",
+			host:      "app.example.com",
+			proto:     "http",
+			forwarded: "for=198.51.100.7;host=app.example.com;proto=http",
+		},
+		{
+			name:    "trusted proxy headers are honored",
+			trusted: []string{"10.0.0.0/8"},
+			remote:  "10.0.0.2:4321",
+			headers: map[string]string{
+				"X-Forwarded-For":   "203.0.113.9",
+				"X-Forwarded-Host":  "public.example.com",
+				"X-Forwarded-Proto": "HTTPS",
+				"Forwarded":         "for=203.0.113.9;proto=https",
+			},
+			clientIP:  "203.0.113.9",
+			chain:     "203.0.113.9, 10.0.0.2",
+			host:      "public.example.com",
+			proto:     "https",
+			forwarded: "for=203.0.113.9;proto=https, for=10.0.0.2;host=public.example.com;proto=https",
+		},
+		{
+			name:    "client is the right-most untrusted hop",
+			trusted: []string{"10.0.0.0/8"},
+			remote:  "10.0.0.2:4321",
+			headers: map[string]string{
+				"X-Forwarded-For": "192.0.2.66, 203.0.113.9, 10.0.0.3",
+			},
+			clientIP:  "203.0.113.9",
+			chain:     "192.0.2.66, 203.0.113.9, 10.0.0.3, 10.0.0.2",
+			host:      "app.example.com",
+			proto:     "http",
+			forwarded: "for=10.0.0.2;host=app.example.com;proto=http",
+		},
+		{
+			name:    "every hop trusted falls back to the first",
+			trusted: []string{"10.0.0.0/8"},
+			remote:  "10.0.0.2:4321",
+			headers: map[string]string{
+				"X-Forwarded-For": "10.0.0.4, 10.0.0.3",
+			},
+			clientIP:  "10.0.0.4",
+			chain:     "10.0.0.4, 10.0.0.3, 10.0.0.2",
+			host:      "app.example.com",
+			proto:     "http",
+			forwarded: "for=10.0.0.2;host=app.example.com;proto=http",
+		},
+		{
+			name:      "IPv6 peer is quoted",
+			remote:    "[2001:db8::1]:4321",
+			clientIP:  "2001:db8::1",
+			chain:     "2001:db8::1",
+			host:      "app.example.com",
+			proto:     "http",
+			forwarded: `for="[2001:db8::1]";host=app.example.com;proto=http`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("token", "example.com", "0")
+			if err := s.SetTrustedProxies(tt.trusted); err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest("GET", "http://app.example.com/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+
+			info := s.forwardedFor(r)
+			if info.clientIP != tt.clientIP {
+				t.Errorf("clientIP = %q, want %q", info.clientIP, tt.clientIP)
+			}
+
+			headers := httpheader.Map{
+				"X-Forwarded-User": {"spoofed"},
+				"Forwarded":        {"for=spoofed"},
+			}
+			info.apply(headers)
+			want := map[string]string{
+				"X-Forwarded-For":   tt.chain,
+				"X-Forwarded-Host":  tt.host,
+				"X-Forwarded-Proto": tt.proto,
+				"Forwarded":         tt.forwarded,
+				"X-Forwarded-User":  "",
+			}
+			for name, value := range want {
+				if got := headers.Get(name); got != value {
+					t.Errorf("%s = %q, want %q", name, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardedValue(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"example.com", "example.com"},
+		{"example.com:8080", `"example.com:8080"`},
+		{"a b", `"a b"`},
+	}
+	for _, tt := range tests {
+		if got := forwardedValue(tt.in); got != tt.want {
+			t.Errorf("forwardedValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+)
+
+// ipRules holds CIDR-based access rules. Deny entries always win; a
+// non-empty allow list rejects every address it does not contain.
+type ipRules struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// newIPRules parses allow and deny lists of CIDRs or bare IPs. It returns
+// nil when both are empty.
+func newIPRules(allow, deny []string) (*ipRules, error) {
+	allowed, err := parsePrefixes(allow)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %w", err)
+	}
+	denied, err := parsePrefixes(deny)
+	if err != nil {
+		return nil, fmt.Errorf("deny list: %w", err)
+	}
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil, nil
+	}
+	return &ipRules{allow: allowed, deny: denied}, nil
+}
+
+// parseTunnelIPRules reads the per-tunnel rules a client registered in its
+// handshake.
+func parseTunnelIPRules(r *http.Request) (*ipRules, error) {
+	query := r.URL.Query()
+	return newIPRules(splitList(query.Get("allow_ips")), splitList(query.Get("deny_ips")))
+}
+
+// permits reports whether ip may reach a tunnel. A nil rule set permits all.
+func (rules *ipRules) permits(ip string) bool {
+	if rules == nil {
+		return true
+	}
+	if containsIP(rules.deny, ip) {
+		return false
+	}
+	return len(rules.allow) == 0 || containsIP(rules.allow, ip)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPRulesPermits(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		ip    string
+		want  bool
+	}{
+		{name: "no rules", ip: "203.0.113.9", want: true},
+		{name: "allowed CIDR", allow: []string{"203.0.113.0/24"}, ip: "203.0.113.9", want: true},
+		{name: "outside allow list", allow: []string{"203.0.113.0/24"}, ip: "198.51.100.1"},
+		{name: "allowed bare IP", allow: []string{"203.0.113.9"}, ip: "203.0.113.9", want: true},
+		{name: "denied CIDR", deny: []string{"203.0.113.0/24"}, ip: "203.0.113.9"},
+		{name: "outside deny list", deny: []string{"203.0.113.0/24"}, ip: "198.51.100.1", want: true},
+		{name: "deny wins over allow", allow: []string{"203.0.113.0/24"}, deny: []string{"203.0.113.9"}, ip: "203.0.113.9"},
+		{name: "IPv6 allowed", allow: []string{"2001:db8::/32"}, ip: "2001:db8::1", want: true},
+		{name: "IPv6 outside IPv4 allow list", allow: []string{"203.0.113.0/24"}, ip: "2001:db8::1"},
+		{name: "mapped IPv4 matches", allow: []string{"203.0.113.0/24"}, ip: "::ffff:203.0.113.9", want: true},
+		{name: "unparsable address outside allow list", allow: []string{"0.0.0.0/0"}, ip: "garbage"},
+		{name: "unparsable address with deny only", deny: []string{"203.0.113.0/24"}, ip: "garbage", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := newIPRules(tt.allow, tt.deny)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rules.permits(tt.ip); got != tt.want {
+				t.Errorf("permits(%q) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewIPRules(t *testing.T) {
+	if rules, err := newIPRules(nil, []string{""}); rules != nil || err != nil {
+		t.Errorf("empty lists = (%v, %v), want (nil, nil)", rules, err)
+	}
+	if _, err := newIPRules([]string{"10.0.0.0/99"}, nil); err == nil {
+		t.Error("expected an error for an invalid allow entry")
+	}
+	if _, err := newIPRules(nil, []string{"nope"}); err == nil {
+		t.Error("expected an error for an invalid deny entry")
+	}
+}
+
+func TestParseTunnelIPRules(t *testing.T) {
+	r := httptest.NewRequest("GET", "/?allow_ips=10.0.0.0/8,+192.0.2.1&deny_ips=10.0.0.5", nil)
+	rules, err := parseTunnelIPRules(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.1.2.3":  true,
+		"192.0.2.1": true,
+		"10.0.0.5":  false,
+		"192.0.2.2": false,
+	} {
+		if got := rules.permits(ip); got != want {
+			t.Errorf("permits(%q) = %v, want %v", ip, got, want)
+		}
+	}
+}
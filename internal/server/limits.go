@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterIdleTimeout is how long an unused limiter is kept before the sweep
+// discards it.
+const limiterIdleTimeout = 10 * time.Minute
+
+// Limit configures a token bucket and a cap on concurrent requests. Zero
+// values disable the corresponding check; Burst defaults to one second's
+// worth of requests.
+type Limit struct {
+	RequestsPerSecond float64
+	Burst             int
+	MaxInFlight       int
+}
+
+// Limits groups the limits applied per tunnel, per auth token and per
+// source IP.
+type Limits struct {
+	Tunnel Limit
+	Token  Limit
+	IP     Limit
+}
+
+type limiter struct {
+	bucket   *rate.Limiter
+	inFlight int
+	lastUsed time.Time
+}
+
+// limiterSet tracks one Limit for many keys, such as every source IP.
+type limiterSet struct {
+	mu      sync.Mutex
+	limit   Limit
+	entries map[string]*limiter
+}
+
+// grant is an admitted request's hold on a limiter.
+type grant struct {
+	set         *limiterSet
+	key         string
+	reservation *rate.Reservation
+	reservedAt  time.Time
+}
+
+func newLimiterSet(limit Limit) *limiterSet {
+	if limit.RequestsPerSecond <= 0 && limit.MaxInFlight <= 0 {
+		return nil
+	}
+	return &limiterSet{limit: limit, entries: make(map[string]*limiter)}
+}
+
+// acquire admits a request for key, or reports how long to wait before
+// retrying. A nil set admits everything.
+func (ls *limiterSet) acquire(key string) (*grant, time.Duration, bool) {
+	if ls == nil {
+		return nil, 0, true
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	l, ok := ls.entries[key]
+	if !ok {
+		l = &limiter{}
+		if ls.limit.RequestsPerSecond > 0 {
+			burst := ls.limit.Burst
+			if burst <= 0 {
+				burst = max(1, int(math.Ceil(ls.limit.RequestsPerSecond)))
+			}
+			l.bucket = rate.NewLimiter(rate.Limit(ls.limit.RequestsPerSecond), burst)
+		}
+		ls.entries[key] = l
+	}
+	l.lastUsed = time.Now()
+
+	if ls.limit.MaxInFlight > 0 && l.inFlight >= ls.limit.MaxInFlight {
+		return nil, time.Second, false
+	}
+
+	g := &grant{set: ls, key: key, reservedAt: l.lastUsed}
+	if l.bucket != nil {
+		g.reservation = l.bucket.ReserveN(g.reservedAt, 1)
+		if delay := g.reservation.DelayFrom(g.reservedAt); delay > 0 {
+			g.reservation.CancelAt(g.reservedAt)
+			return nil, delay, false
+		}
+	}
+
+	l.inFlight++
+	return g, 0, true
+}
+
+// release frees the grant's in-flight slot once the request completes.
+func (g *grant) release() {
+	if g == nil {
+		return
+	}
+	g.set.mu.Lock()
+	defer g.set.mu.Unlock()
+	if l, ok := g.set.entries[g.key]; ok && l.inFlight > 0 {
+		l.inFlight--
+	}
+}
+
+// cancel undoes an admission that a later check rejected, returning the
+// bucket token as well. Cancelling at the reservation time matters: a plain
+// Cancel refunds nothing once that time has passed.
+func (g *grant) cancel() {
+	if g == nil {
+		return
+	}
+	if g.reservation != nil {
+		g.reservation.CancelAt(g.reservedAt)
+	}
+	g.release()
+}
+
+// sweep forgets limiters that have been idle for a while.
+func (ls *limiterSet) sweep() {
+	if ls == nil {
+		return
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	for key, l := range ls.entries {
+		if l.inFlight == 0 && time.Since(l.lastUsed) > limiterIdleTimeout {
+			delete(ls.entries, key)
+		}
+	}
+}
+
+// rateLimiter applies Limits to public requests.
+type rateLimiter struct {
+	tunnel *limiterSet
+	token  *limiterSet
+	ip     *limiterSet
+	done   chan struct{} // closed to end the sweep loop
+}
+
+func newRateLimiter(limits Limits) *rateLimiter {
+	return &rateLimiter{
+		tunnel: newLimiterSet(limits.Tunnel),
+		token:  newLimiterSet(limits.Token),
+		ip:     newLimiterSet(limits.IP),
+		done:   make(chan struct{}),
+	}
+}
+
+// admit checks every limit for a request, returning a function that must be
+// called when it completes. On rejection it returns the suggested wait.
+func (rl *rateLimiter) admit(tunnel, token, ip string) (func(), time.Duration, bool) {
+	if rl == nil {
+		return func() {}, 0, true
+	}
+
+	checks := []struct {
+		set *limiterSet
+		key string
+	}{
+		{rl.tunnel, tunnel},
+		{rl.token, token},
+		{rl.ip, ip},
+	}
+
+	var grants []*grant
+	for _, check := range checks {
+		g, retryAfter, ok := check.set.acquire(check.key)
+		if !ok {
+			for _, granted := range grants {
+				granted.cancel()
+			}
+			return nil, retryAfter, false
+		}
+		grants = append(grants, g)
+	}
+
+	return func() {
+		for _, g := range grants {
+			g.release()
+		}
+	}, 0, true
+}
+
+// sweepLoop drops idle limiter entries until the limiter is stopped or ctx
+// is done.
+func (rl *rateLimiter) sweepLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rl.tunnel.sweep()
+			rl.token.sweep()
+			rl.ip.sweep()
+		case <-rl.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stop ends the sweep loop of a limiter that has been replaced. Requests
+// admitted by it still release their grants normally.
+func (rl *rateLimiter) stop() {
+	if rl != nil {
+		close(rl.done)
+	}
+}
+
+// tooManyRequests writes a 429 with a Retry-After rounded up to whole seconds.
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := max(1, int(math.Ceil(retryAfter.Seconds())))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
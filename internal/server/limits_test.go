@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAdmit(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   Limits
+		requests []string // source IP of each request, all on one tunnel
+		release  bool     // complete each request before the next
+		want     []bool
+	}{
+		{
+			name:     "no limits",
+			requests: []string{"a", "a", "a"},
+			want:     []bool{true, true, true},
+		},
+		{
+			name:     "tunnel burst",
+			limits:   Limits{Tunnel: Limit{RequestsPerSecond: 0.001, Burst: 2}},
+			requests: []string{"a", "b", "c"},
+			release:  true,
+			want:     []bool{true, true, false},
+		},
+		{
+			name:     "burst defaults to one second of requests",
+			limits:   Limits{Tunnel: Limit{RequestsPerSecond: 2}},
+			requests: []string{"a", "a", "a"},
+			release:  true,
+			want:     []bool{true, true, false},
+		},
+		{
+			name:     "per IP",
+			limits:   Limits{IP: Limit{RequestsPerSecond: 0.001, Burst: 1}},
+			requests: []string{"a", "b", "a", "b"},
+			release:  true,
+			want:     []bool{true, true, false, false},
+		},
+		{
+			name:     "in-flight cap",
+			limits:   Limits{Tunnel: Limit{MaxInFlight: 2}},
+			requests: []string{"a", "b", "c"},
+			want:     []bool{true, true, false},
+		},
+		{
+			name:     "in-flight cap frees on release",
+			limits:   Limits{Tunnel: Limit{MaxInFlight: 1}},
+			requests: []string{"a", "b", "c"},
+			release:  true,
+			want:     []bool{true, true, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rl *rateLimiter
+			if tt.limits != (Limits{}) {
+				rl = newRateLimiter(tt.limits)
+			}
+			for i, ip := range tt.requests {
+				done, retryAfter, ok := rl.admit("app", "default", ip)
+				if ok != tt.want[i] {
+					t.Fatalf("request %d admitted = %v, want %v", i, ok, tt.want[i])
+				}
+				if !ok && retryAfter <= 0 {
+					t.Errorf("request %d rejected without a retry hint", i)
+				}
+				if ok && tt.release {
+					done()
+				}
+			}
+		})
+	}
+}
+
+// A request rejected by a later limit must not use up earlier ones.
+func TestRateLimiterRejectionRefunds(t *testing.T) {
+	rl := newRateLimiter(Limits{
+		Tunnel: Limit{RequestsPerSecond: 0.001, Burst: 1, MaxInFlight: 5},
+		IP:     Limit{MaxInFlight: 1},
+	})
+
+	done, _, ok := rl.admit("app", "default", "a")
+	if !ok {
+		t.Fatal("first request rejected")
+	}
+	if _, _, ok := rl.admit("other", "default", "a"); ok {
+		t.Fatal("second request from the same IP admitted past its in-flight cap")
+	}
+	done()
+
+	if _, _, ok := rl.admit("other", "default", "b"); !ok {
+		t.Error("tunnel bucket was charged for a rejected request")
+	}
+}
+
+func TestLimiterSetSweep(t *testing.T) {
+	ls := newLimiterSet(Limit{MaxInFlight: 1})
+	g, _, _ := ls.acquire("busy")
+	ls.acquire("idle")
+	g2, _, _ := ls.acquire("done")
+	g2.release()
+
+	for _, l := range ls.entries {
+		l.lastUsed = time.Now().Add(-2 * limiterIdleTimeout)
+	}
+	ls.sweep()
+
+	if _, ok := ls.entries["done"]; ok {
+		t.Error("idle limiter was kept")
+	}
+	if _, ok := ls.entries["busy"]; !ok {
+		t.Error("limiter with a request in flight was dropped")
+	}
+	g.release()
+}
+
+func TestTooManyRequests(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		want       string
+	}{
+		{0, "1"},
+		{300 * time.Millisecond, "1"},
+		{time.Second, "1"},
+		{1500 * time.Millisecond, "2"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tooManyRequests(w, tt.retryAfter)
+		if w.Code != 429 || w.Header().Get("Retry-After") != tt.want {
+			t.Errorf("tooManyRequests(%v) = %d with Retry-After %q, want 429 with %q",
+				tt.retryAfter, w.Code, w.Header().Get("Retry-After"), tt.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// reconnectWindow is how soon after a disconnect a client claiming the same
+// subdomain again counts as a reconnect.
+const reconnectWindow = 5 * time.Minute
+
+// noTunnel labels requests that did not match a connected tunnel, keeping
+// unknown hosts from creating new series.
+const noTunnel = "-"
+
+// Reasons a public request is rejected before or instead of being answered
+// by a tunnel.
+const (
+	rejectBusy         = "busy"
+	rejectTimeout      = "timeout"
+	rejectCancelled    = "cancelled"
+	rejectRateLimited  = "rate_limited"
+	rejectQuota        = "quota_exceeded"
+	rejectForbidden    = "forbidden"
+	rejectNotFound     = "not_found"
+	rejectShuttingDown = "shutting_down"
+)
+
+type metrics struct {
+	registry    *prometheus.Registry
+	requests    *prometheus.CounterVec
+	roundTrip   *prometheus.HistogramVec
+	bytes       *prometheus.CounterVec
+	rejections  *prometheus.CounterVec
+	connections prometheus.Counter
+	reconnects  prometheus.Counter
+
+	// tunnelsMu orders observations against forgetTunnel, so a request that
+	// finishes after its tunnel disconnected cannot recreate its series.
+	tunnelsMu sync.RWMutex
+	tunnels   map[string]bool // connected tunnels with series
+}
+
+func newMetrics(s *Server) *metrics {
+	m := &metrics{
+		tunnels:  make(map[string]bool),
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dr1ll_requests_total",
+			Help: "Public requests handled, by tunnel and response status code.",
+		}, []string{"tunnel", "code"}),
+		roundTrip: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "dr1ll_tunnel_roundtrip_seconds",
+			Help:    "Time from sending a request down a tunnel to receiving its response.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"tunnel"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dr1ll_bytes_total",
+			Help: "Body bytes carried through tunnels; direction is in (requests) or out (responses).",
+		}, []string{"tunnel", "direction"}),
+		rejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dr1ll_rejected_requests_total",
+			Help: "Public requests not answered by a tunnel, by reason.",
+		}, []string{"reason"}),
+		connections: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dr1ll_websocket_connections_total",
+			Help: "Tunnel WebSocket connections accepted.",
+		}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dr1ll_websocket_reconnects_total",
+			Help: "Tunnel connections that reclaimed a recently disconnected subdomain.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests, m.roundTrip, m.bytes, m.rejections, m.connections, m.reconnects,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "dr1ll_active_tunnels",
+			Help: "Tunnels currently connected.",
+		}, func() float64 {
+			s.mutex.RLock()
+			defer s.mutex.RUnlock()
+			return float64(len(s.clients))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "dr1ll_pending_requests",
+			Help: "Requests waiting for a tunnel response.",
+		}, func() float64 {
+			s.pendingRequestsMu.RLock()
+			defer s.pendingRequestsMu.RUnlock()
+			return float64(len(s.pendingRequests))
+		}),
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observeRequest counts a public request. Requests to a tunnel that has
+// disconnected since are counted as noTunnel.
+func (m *metrics) observeRequest(tunnel string, status int) {
+	m.tunnelsMu.RLock()
+	defer m.tunnelsMu.RUnlock()
+	if !m.tunnels[tunnel] {
+		tunnel = noTunnel
+	}
+	m.requests.WithLabelValues(tunnel, strconv.Itoa(status)).Inc()
+}
+
+func (m *metrics) observeRoundTrip(tunnel string, elapsed time.Duration, bytesIn, bytesOut int) {
+	m.tunnelsMu.RLock()
+	defer m.tunnelsMu.RUnlock()
+	if !m.tunnels[tunnel] {
+		return
+	}
+	m.roundTrip.WithLabelValues(tunnel).Observe(elapsed.Seconds())
+	m.bytes.WithLabelValues(tunnel, "in").Add(float64(bytesIn))
+	m.bytes.WithLabelValues(tunnel, "out").Add(float64(bytesOut))
+}
+
+func (m *metrics) reject(reason string) {
+	m.rejections.WithLabelValues(reason).Inc()
+}
+
+// addTunnel starts recording series for a connected tunnel.
+func (m *metrics) addTunnel(tunnel string) {
+	m.tunnelsMu.Lock()
+	m.tunnels[tunnel] = true
+	m.tunnelsMu.Unlock()
+}
+
+// forgetTunnel drops the series of a disconnected tunnel so random
+// subdomains do not accumulate forever.
+func (m *metrics) forgetTunnel(tunnel string) {
+	m.tunnelsMu.Lock()
+	defer m.tunnelsMu.Unlock()
+	delete(m.tunnels, tunnel)
+
+	labels := prometheus.Labels{"tunnel": tunnel}
+	m.requests.DeletePartialMatch(labels)
+	m.roundTrip.DeletePartialMatch(labels)
+	m.bytes.DeletePartialMatch(labels)
+}
+
+// statusRecorder captures the status code and body size written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Status returns the response code, which is 200 if nothing was written.
+func (r *statusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
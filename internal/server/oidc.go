@@ -0,0 +1,468 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// The provider redirects to a single callback on the server domain, so one
+// redirect URI covers every tunnel. The callback hands the signed-in
+// identity back to the tunnel host, which sets its own session cookie.
+const (
+	oidcCallbackPath = "/.dr1ll/oauth2/callback"
+	oidcSessionPath  = "/.dr1ll/oauth2/session"
+	oidcLogoutPath   = "/.dr1ll/oauth2/logout"
+
+	sessionCookie = "dr1ll_session"
+	stateCookie   = "dr1ll_oidc_state"
+
+	stateTTL   = 10 * time.Minute
+	handoffTTL = time.Minute
+)
+
+// Purposes bind each signed value to one use, so a login state or handoff
+// shown in a URL can never be replayed as a session cookie.
+const (
+	purposeSession = "session"
+	purposeState   = "state"
+	purposeHandoff = "handoff"
+)
+
+// OIDCConfig configures the optional login gateway that tunnels can opt into.
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string // in addition to "openid"; defaults to email and profile
+
+	// CookieSecret signs session cookies. A random secret is generated when
+	// empty, which logs everyone out on restart.
+	CookieSecret string
+	SessionTTL   time.Duration
+
+	// AllowedDomains and AllowedGroups apply to every gated tunnel; tunnels
+	// can narrow them further in their handshake.
+	AllowedDomains []string
+	AllowedGroups  []string
+	GroupsClaim    string // defaults to "groups"
+}
+
+// loginPolicy restricts who may pass the gateway. Empty lists allow anyone.
+type loginPolicy struct {
+	domains []string
+	groups  []string
+}
+
+// identity is the signed-in user carried in the session cookie.
+type identity struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Host    string   `json:"host"`
+	Expiry  int64    `json:"exp"`
+}
+
+// valid reports whether the session belongs to host and has not expired.
+func (id *identity) valid(host string) bool {
+	return id.Subject != "" && id.Host == host && time.Now().Unix() < id.Expiry
+}
+
+// name is the value forwarded to the local app as X-Forwarded-User.
+func (id *identity) name() string {
+	if id.Email != "" {
+		return id.Email
+	}
+	return id.Subject
+}
+
+// loginState is signed and sent as the OAuth state parameter, so the
+// callback on the server domain knows which tunnel host started the login.
+type loginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Host     string `json:"host"`
+	Proto    string `json:"proto"`
+	ReturnTo string `json:"return_to"`
+	Expiry   int64  `json:"exp"`
+}
+
+// handoff carries the identity from the callback to the tunnel host. It is
+// bound to the browser that started the login by the state cookie.
+type handoff struct {
+	Identity identity `json:"id"`
+	State    string   `json:"state"`
+	ReturnTo string   `json:"return_to"`
+	Expiry   int64    `json:"exp"`
+}
+
+type oidcGateway struct {
+	config   OIDCConfig
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+	secret   []byte
+	policy   loginPolicy
+	domain   string // server domain hosting the callback
+}
+
+// EnableOIDC discovers the provider at cfg.IssuerURL and makes the login
+// gateway available to tunnels that request it.
+func (s *Server) EnableOIDC(ctx context.Context, cfg OIDCConfig) error {
+	if cfg.IssuerURL == "" || cfg.ClientID == "" {
+		return errors.New("OIDC issuer URL and client ID are required")
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = 12 * time.Hour
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"email", "profile"}
+	}
+
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+
+	secret := []byte(cfg.CookieSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+
+	s.oidc = &oidcGateway{
+		config:   cfg,
+		provider: provider,
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		secret:   secret,
+		policy:   loginPolicy{domains: cfg.AllowedDomains, groups: cfg.AllowedGroups},
+		domain:   s.domain,
+	}
+	return nil
+}
+
+// parseLoginPolicy reads the login gate a client requested in its handshake.
+// It returns nil when the tunnel does not require a login.
+func parseLoginPolicy(r *http.Request) (*loginPolicy, error) {
+	query := r.URL.Query()
+	switch query.Get("login") {
+	case "":
+		return nil, nil
+	case "oidc":
+	default:
+		return nil, fmt.Errorf("unsupported login method %q", query.Get("login"))
+	}
+
+	return &loginPolicy{
+		domains: splitList(query.Get("login_domains")),
+		groups:  splitList(query.Get("login_groups")),
+	}, nil
+}
+
+// authenticate gates a public request. It returns the signed-in identity, or
+// nil after it has written a redirect or error response itself.
+func (g *oidcGateway) authenticate(w http.ResponseWriter, r *http.Request, fwd forwardedInfo, policy *loginPolicy) *identity {
+	switch r.URL.Path {
+	case oidcSessionPath:
+		g.handleSession(w, r, fwd, policy)
+		return nil
+	case oidcLogoutPath:
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+		http.Error(w, "Signed out", http.StatusOK)
+		return nil
+	}
+
+	var id identity
+	if cookie, err := r.Cookie(sessionCookie); err == nil &&
+		g.decode(purposeSession, cookie.Value, &id) && id.valid(fwd.host) {
+		if !g.allowed(&id, policy) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return nil
+		}
+		return &id
+	}
+
+	// Only navigations can follow a login redirect; API calls get a 401.
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	state := loginState{
+		State:    randomString(16),
+		Nonce:    randomString(16),
+		Host:     fwd.host,
+		Proto:    fwd.proto,
+		ReturnTo: r.URL.RequestURI(),
+		Expiry:   time.Now().Add(stateTTL).Unix(),
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state.State,
+		Path:     oidcSessionPath,
+		MaxAge:   int(stateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   fwd.proto == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	authURL := g.oauth2Config(state).AuthCodeURL(g.encode(purposeState, state), oidc.Nonce(state.Nonce))
+	http.Redirect(w, r, authURL, http.StatusFound)
+	return nil
+}
+
+// isCallback reports whether r is the provider redirecting back to the
+// callback on the server domain.
+func (g *oidcGateway) isCallback(r *http.Request) bool {
+	hostname := r.Host
+	if h, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = h
+	}
+	return r.URL.Path == oidcCallbackPath && strings.EqualFold(hostname, g.domain)
+}
+
+// handleCallback completes the login on the server domain and hands the
+// identity to the tunnel host named in the signed state.
+func (g *oidcGateway) handleCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var state loginState
+	if !g.decode(purposeState, query.Get("state"), &state) || time.Now().Unix() >= state.Expiry {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	if errCode := query.Get("error"); errCode != "" {
+		http.Error(w, "Login failed: "+errCode, http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	token, err := g.oauth2Config(state).Exchange(ctx, query.Get("code"))
+	if err != nil {
+		slog.Warn("OIDC code exchange failed", "host", state.Host, "error", err)
+		http.Error(w, "Login failed", http.StatusBadGateway)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		http.Error(w, "Login failed: no ID token", http.StatusBadGateway)
+		return
+	}
+	idToken, err := g.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		slog.Warn("OIDC ID token rejected", "host", state.Host, "error", err)
+		http.Error(w, "Login failed", http.StatusForbidden)
+		return
+	}
+	if !secureEqual(idToken.Nonce, state.Nonce) {
+		http.Error(w, "Invalid login nonce", http.StatusForbidden)
+		return
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		http.Error(w, "Login failed", http.StatusBadGateway)
+		return
+	}
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		http.Error(w, "Email address is not verified", http.StatusForbidden)
+		return
+	}
+
+	id := identity{
+		Subject: idToken.Subject,
+		Host:    state.Host,
+		Expiry:  time.Now().Add(g.config.SessionTTL).Unix(),
+	}
+	id.Email, _ = claims["email"].(string)
+	id.Groups = claimStrings(claims[g.config.GroupsClaim])
+
+	session := g.encode(purposeHandoff, handoff{
+		Identity: id,
+		State:    state.State,
+		ReturnTo: state.ReturnTo,
+		Expiry:   time.Now().Add(handoffTTL).Unix(),
+	})
+	target := url.URL{
+		Scheme:   state.Proto,
+		Host:     state.Host,
+		Path:     oidcSessionPath,
+		RawQuery: url.Values{"session": {session}}.Encode(),
+	}
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// handleSession runs on the tunnel host: it checks the identity handed over
+// by the callback and sets the tunnel's session cookie.
+func (g *oidcGateway) handleSession(w http.ResponseWriter, r *http.Request, fwd forwardedInfo, policy *loginPolicy) {
+	var h handoff
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil || !g.decode(purposeHandoff, r.URL.Query().Get("session"), &h) ||
+		time.Now().Unix() >= h.Expiry || h.Identity.Subject == "" || h.Identity.Host != fwd.host {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: oidcSessionPath, MaxAge: -1})
+	if !secureEqual(cookie.Value, h.State) {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	id := h.Identity
+	if !g.allowed(&id, policy) {
+		slog.Info("OIDC login denied", "host", fwd.host, "user", id.name())
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    g.encode(purposeSession, id),
+		Path:     "/",
+		MaxAge:   int(g.config.SessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   fwd.proto == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	returnTo := h.ReturnTo
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+		returnTo = "/"
+	}
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+// oauth2Config builds the client config for a login. The redirect URL is the
+// callback on the server domain, reached with the scheme and port the tunnel
+// host was.
+func (g *oidcGateway) oauth2Config(state loginState) *oauth2.Config {
+	host := g.domain
+	if _, port, err := net.SplitHostPort(state.Host); err == nil {
+		host = net.JoinHostPort(host, port)
+	}
+	return &oauth2.Config{
+		ClientID:     g.config.ClientID,
+		ClientSecret: g.config.ClientSecret,
+		Endpoint:     g.provider.Endpoint(),
+		RedirectURL:  state.Proto + "://" + host + oidcCallbackPath,
+		Scopes:       append([]string{oidc.ScopeOpenID}, g.config.Scopes...),
+	}
+}
+
+// allowed checks id against the server-wide policy and the tunnel's own.
+func (g *oidcGateway) allowed(id *identity, policy *loginPolicy) bool {
+	return g.policy.allows(id) && (policy == nil || policy.allows(id))
+}
+
+func (p loginPolicy) allows(id *identity) bool {
+	if len(p.domains) > 0 {
+		_, domain, ok := strings.Cut(id.Email, "@")
+		if !ok || !slices.ContainsFunc(p.domains, func(d string) bool { return strings.EqualFold(d, domain) }) {
+			return false
+		}
+	}
+	if len(p.groups) > 0 && !slices.ContainsFunc(id.Groups, func(g string) bool { return slices.Contains(p.groups, g) }) {
+		return false
+	}
+	return true
+}
+
+// encode serializes v into a value signed with the gateway secret for
+// purpose.
+func (g *oidcGateway) encode(purpose string, v any) string {
+	payload, _ := json.Marshal(v)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(g.sign(purpose, encoded))
+}
+
+// decode verifies and deserializes a value encode produced for purpose.
+func (g *oidcGateway) decode(purpose, value string, v any) bool {
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, g.sign(purpose, encoded)) {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(payload, v) == nil
+}
+
+func (g *oidcGateway) sign(purpose, data string) []byte {
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write([]byte(purpose + "\x00" + data))
+	return mac.Sum(nil)
+}
+
+// stripSessionCookie removes the gateway's cookies from the Cookie header so
+// they are not leaked to the local app.
+func stripSessionCookie(header http.Header) {
+	cookies := (&http.Request{Header: header}).Cookies()
+	header.Del("Cookie")
+
+	var kept []string
+	for _, cookie := range cookies {
+		if cookie.Name != sessionCookie && cookie.Name != stateCookie {
+			kept = append(kept, cookie.String())
+		}
+	}
+	if len(kept) > 0 {
+		header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
+
+// claimStrings reads a claim that may be a single string or a list.
+func claimStrings(claim any) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var values []string
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				values = append(values, str)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func randomString(n int) string {
+	bytes := make([]byte, n)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
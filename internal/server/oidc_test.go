@@ -0,0 +1,455 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockIssuer is a minimal OIDC provider: discovery, JWKS and a token
+// endpoint that returns an ID token for codes handed out by issue.
+type mockIssuer struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]map[string]any
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, codes: make(map[string]map[string]any)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		claims, ok := m.codes[r.FormValue("code")]
+		delete(m.codes, r.FormValue("code"))
+		m.mu.Unlock()
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     m.sign(t, claims),
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// issue returns a code the token endpoint exchanges for an ID token with
+// claims, on top of standard ones for client.
+func (m *mockIssuer) issue(client string, claims map[string]any) string {
+	full := map[string]any{
+		"iss": m.URL,
+		"aud": client,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		full[k] = v
+	}
+	code := randomString(8)
+	m.mu.Lock()
+	m.codes[code] = full
+	m.mu.Unlock()
+	return code
+}
+
+func (m *mockIssuer) sign(t *testing.T, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Error(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newTestGateway(t *testing.T, issuer *mockIssuer, allowedDomains ...string) *oidcGateway {
+	t.Helper()
+	s := NewServer("token", "example.com", "0")
+	err := s.EnableOIDC(context.Background(), OIDCConfig{
+		IssuerURL:      issuer.URL,
+		ClientID:       "client",
+		ClientSecret:   "secret",
+		CookieSecret:   "cookie-secret",
+		AllowedDomains: allowedDomains,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.oidc
+}
+
+var testTunnel = forwardedInfo{host: "app.example.com", proto: "http"}
+
+// login is a browser working through the gateway.
+type login struct {
+	t       *testing.T
+	g       *oidcGateway
+	issuer  *mockIssuer
+	cookies map[string]string
+}
+
+func newLogin(t *testing.T, g *oidcGateway, issuer *mockIssuer) *login {
+	return &login{t: t, g: g, issuer: issuer, cookies: make(map[string]string)}
+}
+
+func (l *login) request(target string) *http.Request {
+	r := httptest.NewRequest("GET", target, nil)
+	for name, value := range l.cookies {
+		r.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+	return r
+}
+
+func (l *login) keep(w *httptest.ResponseRecorder) {
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge < 0 {
+			delete(l.cookies, c.Name)
+		} else {
+			l.cookies[c.Name] = c.Value
+		}
+	}
+}
+
+// visit requests target on the tunnel host through the gateway.
+func (l *login) visit(target string) (*httptest.ResponseRecorder, *identity) {
+	w := httptest.NewRecorder()
+	id := l.g.authenticate(w, l.request(target), testTunnel, nil)
+	l.keep(w)
+	return w, id
+}
+
+// start visits a gated page and returns the provider authorization URL.
+func (l *login) start() *url.URL {
+	l.t.Helper()
+	w, id := l.visit("http://app.example.com/private?x=1")
+	if id != nil || w.Code != http.StatusFound {
+		l.t.Fatalf("gated visit = %d, identity %v, want a redirect", w.Code, id)
+	}
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(authURL.String(), l.issuer.URL+"/authorize") {
+		l.t.Fatalf("redirect to %q, want the provider", w.Header().Get("Location"))
+	}
+	return authURL
+}
+
+// callback delivers code to the server domain callback.
+func (l *login) callback(state, code string) *httptest.ResponseRecorder {
+	query := url.Values{"state": {state}, "code": {code}}
+	r := l.request("http://example.com" + oidcCallbackPath + "?" + query.Encode())
+	if !l.g.isCallback(r) {
+		l.t.Fatalf("%s is not recognized as the callback", r.URL)
+	}
+	w := httptest.NewRecorder()
+	l.g.handleCallback(w, r)
+	return w
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newMockIssuer(t)
+	g := newTestGateway(t, issuer)
+	l := newLogin(t, g, issuer)
+
+	authURL := l.start()
+	query := authURL.Query()
+	if got, want := query.Get("redirect_uri"), "http://example.com"+oidcCallbackPath; got != want {
+		t.Errorf("redirect_uri = %q, want %q", got, want)
+	}
+
+	code := issuer.issue("client", map[string]any{
+		"sub":    "user-1",
+		"email":  "alice@example.com",
+		"groups": []string{"dev"},
+		"nonce":  query.Get("nonce"),
+	})
+	w := l.callback(query.Get("state"), code)
+	if w.Code != http.StatusFound {
+		t.Fatalf("callback = %d %s, want a redirect", w.Code, w.Body)
+	}
+	handoffURL := w.Header().Get("Location")
+	if !strings.HasPrefix(handoffURL, "http://app.example.com"+oidcSessionPath+"?") {
+		t.Fatalf("callback redirected to %q, want the tunnel session path", handoffURL)
+	}
+
+	w, _ = l.visit(handoffURL)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/private?x=1" {
+		t.Fatalf("session = %d to %q, want a redirect to /private?x=1", w.Code, w.Header().Get("Location"))
+	}
+	if _, ok := l.cookies[stateCookie]; ok {
+		t.Error("state cookie was not cleared")
+	}
+
+	_, id := l.visit("http://app.example.com/private?x=1")
+	if id == nil {
+		t.Fatal("session cookie was not accepted")
+	}
+	if id.Subject != "user-1" || id.name() != "alice@example.com" || len(id.Groups) != 1 || id.Groups[0] != "dev" {
+		t.Errorf("identity = %+v", id)
+	}
+
+	// The handoff is single-use in practice: the state cookie is gone.
+	w, _ = l.visit(handoffURL)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("replayed handoff = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims func(nonce string) map[string]any
+		state  func(state string) string
+		want   int
+	}{
+		{
+			name:   "wrong nonce",
+			claims: func(string) map[string]any { return map[string]any{"sub": "user-1", "nonce": "other"} },
+			want:   http.StatusForbidden,
+		},
+		{
+			name: "unverified email",
+			claims: func(nonce string) map[string]any {
+				return map[string]any{"sub": "user-1", "nonce": nonce, "email": "a@example.com", "email_verified": false}
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name:   "tampered state",
+			claims: func(nonce string) map[string]any { return map[string]any{"sub": "user-1", "nonce": nonce} },
+			state:  func(state string) string { return "x" + state },
+			want:   http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newMockIssuer(t)
+			g := newTestGateway(t, issuer)
+			l := newLogin(t, g, issuer)
+
+			query := l.start().Query()
+			state := query.Get("state")
+			if tt.state != nil {
+				state = tt.state(state)
+			}
+			code := issuer.issue("client", tt.claims(query.Get("nonce")))
+			if w := l.callback(state, code); w.Code != tt.want {
+				t.Errorf("callback = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCUnknownCode(t *testing.T) {
+	issuer := newMockIssuer(t)
+	g := newTestGateway(t, issuer)
+	l := newLogin(t, g, issuer)
+
+	query := l.start().Query()
+	if w := l.callback(query.Get("state"), "unknown"); w.Code != http.StatusBadGateway {
+		t.Errorf("callback = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+}
+
+// Signed values are bound to their purpose: the state in the provider URL
+// and the handoff in the tunnel URL must never pass as a session cookie.
+func TestOIDCSignedValuesAreNotSessions(t *testing.T) {
+	issuer := newMockIssuer(t)
+	g := newTestGateway(t, issuer)
+
+	now := time.Now()
+	id := identity{Subject: "user-1", Host: testTunnel.host, Expiry: now.Add(time.Hour).Unix()}
+	tests := []struct {
+		name   string
+		cookie string
+		want   bool
+	}{
+		{name: "session", cookie: g.encode(purposeSession, id), want: true},
+		{
+			name: "login state",
+			cookie: g.encode(purposeState, loginState{
+				State: "s", Host: testTunnel.host, Proto: "http", Expiry: now.Add(time.Hour).Unix(),
+			}),
+		},
+		{name: "handoff", cookie: g.encode(purposeHandoff, handoff{Identity: id, Expiry: now.Add(time.Hour).Unix()})},
+		{name: "identity signed as handoff", cookie: g.encode(purposeHandoff, id)},
+		{name: "empty subject", cookie: g.encode(purposeSession, identity{Host: testTunnel.host, Expiry: now.Add(time.Hour).Unix()})},
+		{name: "other host", cookie: g.encode(purposeSession, identity{Subject: "user-1", Host: "other.example.com", Expiry: now.Add(time.Hour).Unix()})},
+		{name: "expired", cookie: g.encode(purposeSession, identity{Subject: "user-1", Host: testTunnel.host, Expiry: now.Add(-time.Minute).Unix()})},
+		{name: "garbage", cookie: "not.signed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLogin(t, g, issuer)
+			l.cookies[sessionCookie] = tt.cookie
+			_, got := l.visit("http://app.example.com/")
+			if (got != nil) != tt.want {
+				t.Errorf("session accepted = %v, want %v", got != nil, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCHandleSessionRejects(t *testing.T) {
+	issuer := newMockIssuer(t)
+	g := newTestGateway(t, issuer, "example.com")
+
+	now := time.Now()
+	valid := handoff{
+		Identity: identity{Subject: "user-1", Email: "alice@example.com", Host: testTunnel.host, Expiry: now.Add(time.Hour).Unix()},
+		State:    "state-1",
+		ReturnTo: "/",
+		Expiry:   now.Add(time.Minute).Unix(),
+	}
+	tests := []struct {
+		name        string
+		handoff     func(h handoff) string
+		stateCookie string
+		want        int
+	}{
+		{name: "valid", handoff: func(h handoff) string { return g.encode(purposeHandoff, h) }, stateCookie: "state-1", want: http.StatusFound},
+		{name: "no state cookie", handoff: func(h handoff) string { return g.encode(purposeHandoff, h) }, want: http.StatusBadRequest},
+		{name: "other browser", handoff: func(h handoff) string { return g.encode(purposeHandoff, h) }, stateCookie: "state-2", want: http.StatusBadRequest},
+		{name: "signed as session", handoff: func(h handoff) string { return g.encode(purposeSession, h) }, stateCookie: "state-1", want: http.StatusBadRequest},
+		{
+			name:        "expired",
+			handoff:     func(h handoff) string { h.Expiry = now.Add(-time.Second).Unix(); return g.encode(purposeHandoff, h) },
+			stateCookie: "state-1",
+			want:        http.StatusBadRequest,
+		},
+		{
+			name:        "empty subject",
+			handoff:     func(h handoff) string { h.Identity.Subject = ""; return g.encode(purposeHandoff, h) },
+			stateCookie: "state-1",
+			want:        http.StatusBadRequest,
+		},
+		{
+			name:        "other host",
+			handoff:     func(h handoff) string { h.Identity.Host = "other.example.com"; return g.encode(purposeHandoff, h) },
+			stateCookie: "state-1",
+			want:        http.StatusBadRequest,
+		},
+		{
+			name:        "domain not allowed",
+			handoff:     func(h handoff) string { h.Identity.Email = "mallory@evil.com"; return g.encode(purposeHandoff, h) },
+			stateCookie: "state-1",
+			want:        http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLogin(t, g, issuer)
+			if tt.stateCookie != "" {
+				l.cookies[stateCookie] = tt.stateCookie
+			}
+			w, _ := l.visit("http://app.example.com" + oidcSessionPath + "?session=" + url.QueryEscape(tt.handoff(valid)))
+			if w.Code != tt.want {
+				t.Errorf("session = %d, want %d", w.Code, tt.want)
+			}
+			if _, ok := l.cookies[sessionCookie]; ok != (tt.want == http.StatusFound) {
+				t.Errorf("session cookie set = %v", ok)
+			}
+		})
+	}
+}
+
+func TestOIDCNonNavigationGets401(t *testing.T) {
+	issuer := newMockIssuer(t)
+	g := newTestGateway(t, issuer)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "http://app.example.com/api", nil)
+	if id := g.authenticate(w, r, testTunnel, nil); id != nil || w.Code != http.StatusUnauthorized {
+		t.Errorf("POST = %d, identity %v, want %d", w.Code, id, http.StatusUnauthorized)
+	}
+}
+
+func TestLoginPolicyAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy loginPolicy
+		id     identity
+		want   bool
+	}{
+		{name: "open", id: identity{Subject: "u"}, want: true},
+		{name: "domain match", policy: loginPolicy{domains: []string{"Example.com"}}, id: identity{Email: "a@example.com"}, want: true},
+		{name: "domain mismatch", policy: loginPolicy{domains: []string{"example.com"}}, id: identity{Email: "a@evil.com"}},
+		{name: "domain without email", policy: loginPolicy{domains: []string{"example.com"}}, id: identity{Subject: "u"}},
+		{name: "group match", policy: loginPolicy{groups: []string{"ops", "dev"}}, id: identity{Groups: []string{"dev"}}, want: true},
+		{name: "group mismatch", policy: loginPolicy{groups: []string{"ops"}}, id: identity{Groups: []string{"dev"}}},
+		{
+			name:   "domain and group",
+			policy: loginPolicy{domains: []string{"example.com"}, groups: []string{"ops"}},
+			id:     identity{Email: "a@example.com", Groups: []string{"dev"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.allows(&tt.id); got != tt.want {
+				t.Errorf("allows = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripSessionCookie(t *testing.T) {
+	header := http.Header{"Cookie": {"a=1; " + sessionCookie + "=x; " + stateCookie + "=y; b=2"}}
+	stripSessionCookie(header)
+	if got, want := header.Get("Cookie"), "a=1; b=2"; got != want {
+		t.Errorf("Cookie = %q, want %q", got, want)
+	}
+
+	header = http.Header{"Cookie": {sessionCookie + "=x"}}
+	stripSessionCookie(header)
+	if _, ok := header["Cookie"]; ok {
+		t.Errorf("Cookie = %q, want none", header.Get("Cookie"))
+	}
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultTokenName identifies the server's main token, which has no quota.
+const DefaultTokenName = "default"
+
+// usageFlushInterval is how often changed usage counters are persisted.
+const usageFlushInterval = 30 * time.Second
+
+// Quota limits what a token may use. Zero values mean unlimited.
+type Quota struct {
+	BytesPerDay      int64
+	RequestsPerMonth int64
+	MaxTunnels       int
+}
+
+// account is an additional auth token with its own quota.
+type account struct {
+	token string
+	quota Quota
+}
+
+// Usage holds the counters tracked for one token. Day and Month name the
+// periods BytesToday and RequestsThisMonth belong to.
+type Usage struct {
+	Day               string `json:"day"`
+	BytesToday        int64  `json:"bytes_today"`
+	Month             string `json:"month"`
+	RequestsThisMonth int64  `json:"requests_this_month"`
+	BytesTotal        int64  `json:"bytes_total"`
+	RequestsTotal     int64  `json:"requests_total"`
+}
+
+// roll resets counters whose period has ended.
+func (u *Usage) roll(now time.Time) {
+	if day := now.Format(time.DateOnly); u.Day != day {
+		u.Day = day
+		u.BytesToday = 0
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month = month
+		u.RequestsThisMonth = 0
+	}
+}
+
+// Current returns the counters as of now, with ended periods zeroed.
+func (u Usage) Current(now time.Time) Usage {
+	u.roll(now)
+	return u
+}
+
+// UsageStore persists usage counters across restarts.
+type UsageStore interface {
+	LoadUsage() (map[string]*Usage, error)
+	SaveUsage(map[string]*Usage) error
+}
+
+// FileUsageStore keeps usage counters in a JSON file.
+type FileUsageStore struct {
+	path string
+}
+
+func NewFileUsageStore(path string) *FileUsageStore {
+	return &FileUsageStore{path: path}
+}
+
+func (f *FileUsageStore) LoadUsage() (map[string]*Usage, error) {
+	usage := make(map[string]*Usage)
+
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return usage, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, fmt.Errorf("failed to parse usage file: %w", err)
+	}
+	return usage, nil
+}
+
+func (f *FileUsageStore) SaveUsage(usage map[string]*Usage) error {
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves it truncated.
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write usage file: %w", err)
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// usageTracker counts per-token usage for quota enforcement.
+type usageTracker struct {
+	mu    sync.Mutex
+	store UsageStore
+	usage map[string]*Usage
+	dirty bool
+}
+
+func newUsageTracker(store UsageStore) (*usageTracker, error) {
+	usage, err := store.LoadUsage()
+	if err != nil {
+		return nil, err
+	}
+	return &usageTracker{
+		store: store,
+		usage: usage,
+	}, nil
+}
+
+// entry returns the rolled-over counters for a token. Callers hold ut.mu.
+func (ut *usageTracker) entry(token string, now time.Time) *Usage {
+	u, ok := ut.usage[token]
+	if !ok {
+		u = &Usage{}
+		ut.usage[token] = u
+	}
+	u.roll(now)
+	return u
+}
+
+// exceeded reports whether token has used up a periodic quota, and when the
+// exhausted period resets.
+func (ut *usageTracker) exceeded(token string, quota Quota) (time.Duration, bool) {
+	if ut == nil {
+		return 0, false
+	}
+
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	now := time.Now()
+	u := ut.entry(token, now)
+
+	if quota.RequestsPerMonth > 0 && u.RequestsThisMonth >= quota.RequestsPerMonth {
+		nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+		return nextMonth.Sub(now), true
+	}
+	if quota.BytesPerDay > 0 && u.BytesToday >= quota.BytesPerDay {
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		return tomorrow.Sub(now), true
+	}
+	return 0, false
+}
+
+// record adds a completed request and its bytes in both directions.
+func (ut *usageTracker) record(token string, bytes int64) {
+	if ut == nil {
+		return
+	}
+
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	u := ut.entry(token, time.Now())
+	u.RequestsThisMonth++
+	u.RequestsTotal++
+	u.BytesToday += bytes
+	u.BytesTotal += bytes
+	ut.dirty = true
+}
+
+// flush persists the counters if they changed since the last flush.
+func (ut *usageTracker) flush() error {
+	ut.mu.Lock()
+	if !ut.dirty {
+		ut.mu.Unlock()
+		return nil
+	}
+	snapshot := make(map[string]*Usage, len(ut.usage))
+	for token, u := range ut.usage {
+		copied := *u
+		snapshot[token] = &copied
+	}
+	ut.dirty = false
+	ut.mu.Unlock()
+
+	if err := ut.store.SaveUsage(snapshot); err != nil {
+		ut.mu.Lock()
+		ut.dirty = true
+		ut.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// flushLoop saves usage periodically until ctx is done.
+func (ut *usageTracker) flushLoop(ctx context.Context) {
+	ticker := time.NewTicker(usageFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ut.flush(); err != nil {
+				slog.Error("failed to save usage", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUsageRoll(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		in   Usage
+		want Usage
+	}{
+		{
+			name: "same day",
+			in:   Usage{Day: "2024-03-15", BytesToday: 10, Month: "2024-03", RequestsThisMonth: 5, BytesTotal: 100},
+			want: Usage{Day: "2024-03-15", BytesToday: 10, Month: "2024-03", RequestsThisMonth: 5, BytesTotal: 100},
+		},
+		{
+			name: "new day",
+			in:   Usage{Day: "2024-03-14", BytesToday: 10, Month: "2024-03", RequestsThisMonth: 5, BytesTotal: 100},
+			want: Usage{Day: "2024-03-15", Month: "2024-03", RequestsThisMonth: 5, BytesTotal: 100},
+		},
+		{
+			name: "new month",
+			in:   Usage{Day: "2024-02-29", BytesToday: 10, Month: "2024-02", RequestsThisMonth: 5, RequestsTotal: 50},
+			want: Usage{Day: "2024-03-15", Month: "2024-03", RequestsTotal: 50},
+		},
+		{
+			name: "empty",
+			want: Usage{Day: "2024-03-15", Month: "2024-03"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.in.Current(now); got != tt.want {
+				t.Errorf("Current = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageTrackerExceeded(t *testing.T) {
+	tests := []struct {
+		name     string
+		quota    Quota
+		requests int
+		bytes    int64 // per request
+		want     bool
+	}{
+		{name: "unlimited", requests: 100, bytes: 1 << 20},
+		{name: "under request quota", quota: Quota{RequestsPerMonth: 3}, requests: 2},
+		{name: "request quota used up", quota: Quota{RequestsPerMonth: 3}, requests: 3, want: true},
+		{name: "under byte quota", quota: Quota{BytesPerDay: 100}, requests: 1, bytes: 99},
+		{name: "byte quota used up", quota: Quota{BytesPerDay: 100}, requests: 2, bytes: 50, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ut, err := newUsageTracker(NewFileUsageStore(filepath.Join(t.TempDir(), "usage.json")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range tt.requests {
+				ut.record("ci", tt.bytes)
+			}
+			resetIn, got := ut.exceeded("ci", tt.quota)
+			if got != tt.want {
+				t.Fatalf("exceeded = %v, want %v", got, tt.want)
+			}
+			if got && (resetIn <= 0 || resetIn > 31*24*time.Hour) {
+				t.Errorf("reset in %v", resetIn)
+			}
+			if _, other := ut.exceeded("other", tt.quota); other {
+				t.Error("usage of one token counted against another")
+			}
+		})
+	}
+}
+
+func TestUsageTrackerNil(t *testing.T) {
+	var ut *usageTracker
+	ut.record("ci", 10)
+	if _, exceeded := ut.exceeded("ci", Quota{RequestsPerMonth: 1}); exceeded {
+		t.Error("a nil tracker enforced a quota")
+	}
+}
+
+func TestUsagePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "usage.json")
+
+	ut, err := newUsageTracker(NewFileUsageStore(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ut.record("ci", 40)
+	ut.record("ci", 2)
+	if err := ut.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := newUsageTracker(NewFileUsageStore(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := reloaded.usage["ci"]
+	if u == nil || u.RequestsTotal != 2 || u.BytesTotal != 42 || u.RequestsThisMonth != 2 || u.BytesToday != 42 {
+		t.Errorf("reloaded usage = %+v", u)
+	}
+	if _, exceeded := reloaded.exceeded("ci", Quota{RequestsPerMonth: 2}); !exceeded {
+		t.Error("quota was not enforced after reload")
+	}
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// claimTTL is how long a node's claim on a subdomain outlives its last
+// refresh, bounding how long a crashed node keeps its tunnels reserved.
+const claimTTL = 30 * time.Second
+
+// registryTimeout bounds a single registry operation.
+const registryTimeout = 3 * time.Second
+
+// Registry records which server node owns each tunnel subdomain, so that a
+// cluster of servers behind one load balancer can route public requests to
+// the node holding the tunnel. Nodes are identified by the URL other nodes
+// reach them at, e.g. "https://10.0.0.5:9090".
+type Registry interface {
+	// Claim records node as the owner of subdomain for ttl. It returns false
+	// if another node holds a live claim.
+	Claim(ctx context.Context, subdomain, node string, ttl time.Duration) (bool, error)
+	// Refresh extends node's claims on subdomains by ttl, claiming again
+	// those that expired and no other node has taken.
+	Refresh(ctx context.Context, node string, subdomains []string, ttl time.Duration) error
+	// Release drops node's claim on subdomain, if it still holds it.
+	Release(ctx context.Context, subdomain, node string) error
+	// Owner returns the node owning subdomain, or "" if none does.
+	Owner(ctx context.Context, subdomain string) (string, error)
+}
+
+// MemoryRegistry keeps claims in process memory. It is the default and only
+// suits a single server; claims never expire since they die with the
+// process.
+type MemoryRegistry struct {
+	mu     sync.Mutex
+	owners map[string]string
+}
+
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{owners: make(map[string]string)}
+}
+
+func (m *MemoryRegistry) Claim(ctx context.Context, subdomain, node string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if owner, ok := m.owners[subdomain]; ok && owner != node {
+		return false, nil
+	}
+	m.owners[subdomain] = node
+	return true, nil
+}
+
+func (m *MemoryRegistry) Refresh(ctx context.Context, node string, subdomains []string, ttl time.Duration) error {
+	return nil
+}
+
+func (m *MemoryRegistry) Release(ctx context.Context, subdomain, node string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.owners[subdomain] == node {
+		delete(m.owners, subdomain)
+	}
+	return nil
+}
+
+func (m *MemoryRegistry) Owner(ctx context.Context, subdomain string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.owners[subdomain], nil
+}
+
+// redisKeyPrefix namespaces subdomain claims in Redis.
+const redisKeyPrefix = "dr1ll:tunnel:"
+
+// The scripts compare the current owner before changing a key, so a node
+// never extends or drops a claim that has passed to another node. A refresh
+// takes back a claim that expired, e.g. while Redis was unreachable, unless
+// another node got there first.
+var (
+	claimScript = redis.NewScript(`
+local owner = redis.call('GET', KEYS[1])
+if owner == false or owner == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+return 0`)
+
+	refreshScript = redis.NewScript(`
+local owner = redis.call('GET', KEYS[1])
+if owner == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+if owner == false and redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 1
+end
+return 0`)
+
+	releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+)
+
+// RedisRegistry shares claims between nodes through Redis. Claims expire
+// unless refreshed, so tunnels of a node that dies become available again.
+type RedisRegistry struct {
+	client *redis.Client
+}
+
+// NewRedisRegistry connects to the Redis server at url, e.g.
+// "redis://localhost:6379/0".
+func NewRedisRegistry(url string) (*RedisRegistry, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), registryTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &RedisRegistry{client: client}, nil
+}
+
+func (r *RedisRegistry) Claim(ctx context.Context, subdomain, node string, ttl time.Duration) (bool, error) {
+	claimed, err := claimScript.Run(ctx, r.client, []string{redisKeyPrefix + subdomain}, node, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim subdomain: %w", err)
+	}
+	return claimed == 1, nil
+}
+
+func (r *RedisRegistry) Refresh(ctx context.Context, node string, subdomains []string, ttl time.Duration) error {
+	if len(subdomains) == 0 {
+		return nil
+	}
+	// Run cannot fall back from EVALSHA to EVAL inside a pipeline, so a
+	// Redis that has not seen the script yet would fail every refresh.
+	pipe := r.client.Pipeline()
+	for _, subdomain := range subdomains {
+		refreshScript.Eval(ctx, pipe, []string{redisKeyPrefix + subdomain}, node, ttl.Milliseconds())
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to refresh claims: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisRegistry) Release(ctx context.Context, subdomain, node string) error {
+	if err := releaseScript.Run(ctx, r.client, []string{redisKeyPrefix + subdomain}, node).Err(); err != nil {
+		return fmt.Errorf("failed to release subdomain: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisRegistry) Owner(ctx context.Context, subdomain string) (string, error) {
+	owner, err := r.client.Get(ctx, redisKeyPrefix+subdomain).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up subdomain owner: %w", err)
+	}
+	return owner, nil
+}
+
+// Close disconnects from Redis.
+func (r *RedisRegistry) Close() error {
+	return r.client.Close()
+}
+
+// refreshLoop keeps this node's claims alive in a shared registry until
+// Shutdown.
+func (s *Server) refreshLoop() {
+	ticker := time.NewTicker(claimTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.ctx.Done():
+			return
+		}
+
+		s.mutex.RLock()
+		subdomains := make([]string, 0, len(s.clients))
+		for subdomain := range s.clients {
+			subdomains = append(subdomains, subdomain)
+		}
+		s.mutex.RUnlock()
+
+		ctx, cancel := context.WithTimeout(s.ctx, registryTimeout)
+		if err := s.registry.Refresh(ctx, s.nodeURL(), subdomains, claimTTL); err != nil {
+			slog.Error("failed to refresh tunnel claims", "error", err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// registries returns the registries to test: always the memory one, and a
+// Redis one when DR1LL_TEST_REDIS_URL points at a disposable server.
+func registries(t *testing.T) map[string]Registry {
+	t.Helper()
+	regs := map[string]Registry{"memory": NewMemoryRegistry()}
+	if url := os.Getenv("DR1LL_TEST_REDIS_URL"); url != "" {
+		r, err := NewRedisRegistry(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { r.Close() })
+		regs["redis"] = r
+	}
+	return regs
+}
+
+// testSubdomain is unique per run so a shared Redis keeps no stale claims.
+func testSubdomain(name string) string {
+	return name + "-" + randomString(4)
+}
+
+func TestRegistryClaims(t *testing.T) {
+	const nodeA, nodeB = "http://10.0.0.1:9090", "http://10.0.0.2:9090"
+	ctx := context.Background()
+
+	type step struct {
+		op    string // claim, release or owner
+		node  string
+		want  bool   // claim result
+		owner string // owner afterwards
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "claim free subdomain",
+			steps: []step{
+				{op: "claim", node: nodeA, want: true, owner: nodeA},
+			},
+		},
+		{
+			name: "claim again by owner",
+			steps: []step{
+				{op: "claim", node: nodeA, want: true, owner: nodeA},
+				{op: "claim", node: nodeA, want: true, owner: nodeA},
+			},
+		},
+		{
+			name: "claim held by another node",
+			steps: []step{
+				{op: "claim", node: nodeA, want: true, owner: nodeA},
+				{op: "claim", node: nodeB, want: false, owner: nodeA},
+			},
+		},
+		{
+			name: "release by another node is ignored",
+			steps: []step{
+				{op: "claim", node: nodeA, want: true, owner: nodeA},
+				{op: "release", node: nodeB, owner: nodeA},
+			},
+		},
+		{
+			name: "release frees the subdomain",
+			steps: []step{
+				{op: "claim", node: nodeA, want: true, owner: nodeA},
+				{op: "release", node: nodeA, owner: ""},
+				{op: "claim", node: nodeB, want: true, owner: nodeB},
+			},
+		},
+	}
+	for name, reg := range registries(t) {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				subdomain := testSubdomain("app")
+				for i, s := range tt.steps {
+					switch s.op {
+					case "claim":
+						ok, err := reg.Claim(ctx, subdomain, s.node, time.Minute)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if ok != s.want {
+							t.Errorf("step %d: claim by %s = %v, want %v", i, s.node, ok, s.want)
+						}
+					case "release":
+						if err := reg.Release(ctx, subdomain, s.node); err != nil {
+							t.Fatal(err)
+						}
+					}
+					owner, err := reg.Owner(ctx, subdomain)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if owner != s.owner {
+						t.Errorf("step %d: owner = %q, want %q", i, owner, s.owner)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestRedisRegistryExpiry covers the Lua scripts' handling of expired and
+// refreshed claims, which the memory registry does not have.
+func TestRedisRegistryExpiry(t *testing.T) {
+	url := os.Getenv("DR1LL_TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("set DR1LL_TEST_REDIS_URL to run Redis registry tests")
+	}
+	reg, err := NewRedisRegistry(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Close()
+
+	const nodeA, nodeB = "http://10.0.0.1:9090", "http://10.0.0.2:9090"
+	const ttl = 300 * time.Millisecond
+	ctx := context.Background()
+
+	owner := func(subdomain string) string {
+		t.Helper()
+		owner, err := reg.Owner(ctx, subdomain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return owner
+	}
+
+	t.Run("claims expire", func(t *testing.T) {
+		subdomain := testSubdomain("expire")
+		reg.Claim(ctx, subdomain, nodeA, ttl)
+		time.Sleep(2 * ttl)
+		if got := owner(subdomain); got != "" {
+			t.Errorf("owner after expiry = %q", got)
+		}
+		if ok, _ := reg.Claim(ctx, subdomain, nodeB, ttl); !ok {
+			t.Error("expired claim blocked another node")
+		}
+	})
+
+	t.Run("refresh extends claims", func(t *testing.T) {
+		subdomain := testSubdomain("refresh")
+		reg.Claim(ctx, subdomain, nodeA, ttl)
+		for range 3 {
+			time.Sleep(ttl / 2)
+			if err := reg.Refresh(ctx, nodeA, []string{subdomain}, ttl); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := owner(subdomain); got != nodeA {
+			t.Errorf("owner after refreshes = %q, want %q", got, nodeA)
+		}
+	})
+
+	t.Run("refresh reclaims expired claims", func(t *testing.T) {
+		subdomain := testSubdomain("reclaim")
+		reg.Claim(ctx, subdomain, nodeA, ttl)
+		time.Sleep(2 * ttl)
+		if err := reg.Refresh(ctx, nodeA, []string{subdomain}, ttl); err != nil {
+			t.Fatal(err)
+		}
+		if got := owner(subdomain); got != nodeA {
+			t.Errorf("owner = %q, want %q", got, nodeA)
+		}
+	})
+
+	t.Run("refresh does not take another node's claim", func(t *testing.T) {
+		subdomain := testSubdomain("steal")
+		reg.Claim(ctx, subdomain, nodeB, time.Minute)
+		if err := reg.Refresh(ctx, nodeA, []string{subdomain}, ttl); err != nil {
+			t.Fatal(err)
+		}
+		if got := owner(subdomain); got != nodeB {
+			t.Errorf("owner = %q, want %q", got, nodeB)
+		}
+	})
+}
+
+func TestOwnerURL(t *testing.T) {
+	tests := []struct {
+		owner string
+		want  string
+	}{
+		{"https://10.0.0.5:9090", "https://10.0.0.5:9090"},
+		{"http://10.0.0.5:9090", "http://10.0.0.5:9090"},
+		{"10.0.0.5:9090", "http://10.0.0.5:9090"},
+	}
+	for _, tt := range tests {
+		if got := ownerURL(tt.owner); got.String() != tt.want {
+			t.Errorf("ownerURL(%q) = %q, want %q", tt.owner, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Settings are the parts of the server configuration that can change while
+// it runs. Limits may be nil to disable rate limiting.
+type Settings struct {
+	Token          string
+	Tokens         []TokenConfig
+	Limits         *Limits
+	TrustedProxies []string
+	AllowIPs       []string
+	DenyIPs        []string
+}
+
+// TokenConfig is an additional auth token, as passed to AddToken.
+type TokenConfig struct {
+	Name  string
+	Token string
+	Quota Quota
+}
+
+// ApplySettings replaces the server's auth tokens, limits, trusted proxies
+// and IP rules. It validates everything before changing anything, so an
+// invalid configuration leaves the server as it was. Connected tunnels keep
+// running with the new quotas, except those whose token was removed or
+// changed, which are disconnected.
+func (s *Server) ApplySettings(settings Settings) error {
+	if settings.Token == "" {
+		return fmt.Errorf("token is required")
+	}
+	accounts := make(map[string]*account, len(settings.Tokens))
+	for _, t := range settings.Tokens {
+		if t.Name == "" || t.Token == "" {
+			return fmt.Errorf("token name and value are required")
+		}
+		if t.Name == DefaultTokenName {
+			return fmt.Errorf("token name %q is reserved", t.Name)
+		}
+		if _, dup := accounts[t.Name]; dup {
+			return fmt.Errorf("duplicate token name %q", t.Name)
+		}
+		accounts[t.Name] = &account{token: t.Token, quota: t.Quota}
+	}
+	proxies, err := parsePrefixes(settings.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	rules, err := newIPRules(settings.AllowIPs, settings.DenyIPs)
+	if err != nil {
+		return fmt.Errorf("invalid IP rules: %w", err)
+	}
+	var limiter *rateLimiter
+	if settings.Limits != nil {
+		limiter = newRateLimiter(*settings.Limits)
+		go limiter.sweepLoop(s.ctx)
+	}
+
+	s.settingsMu.Lock()
+	revoked := make(map[string]bool)
+	if s.token != settings.Token {
+		revoked[DefaultTokenName] = true
+	}
+	for name, acct := range s.accounts {
+		if next, ok := accounts[name]; !ok || next.token != acct.token {
+			revoked[name] = true
+		}
+	}
+	oldLimiter := s.limiter
+	s.token = settings.Token
+	s.accounts = accounts
+	s.trustedProxies = proxies
+	s.ipRules = rules
+	s.limiter = limiter
+	s.settingsMu.Unlock()
+	oldLimiter.stop()
+
+	s.refreshTunnels(revoked)
+	return nil
+}
+
+// refreshTunnels updates the quota of every connected tunnel and
+// disconnects those whose token is in revoked.
+func (s *Server) refreshTunnels(revoked map[string]bool) {
+	s.mutex.RLock()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mutex.RUnlock()
+
+	for _, client := range clients {
+		if revoked[client.tokenName] {
+			slog.Info("disconnecting tunnel, its token was revoked", "tunnel", client.subdomain, "token", client.tokenName)
+			client.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token revoked"), time.Now().Add(time.Second))
+			client.conn.Close()
+			continue
+		}
+		quota := s.quotaFor(client.tokenName)
+		client.quota.Store(&quota)
+	}
+}
+
+// serverIPRules returns the current server-wide IP rules.
+func (s *Server) serverIPRules() *ipRules {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.ipRules
+}
+
+// rateLimiter returns the current rate limiter, nil if none is set.
+func (s *Server) rateLimiter() *rateLimiter {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.limiter
+}
+
+// SetTLSCertificate serves HTTPS with the certificate and key in the given
+// PEM files. It must first be called before Start; calling it again while
+// running swaps the certificate for new connections.
+func (s *Server) SetTLSCertificate(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	s.certificate.Store(&cert)
+	return nil
+}
+
+func (s *Server) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.certificate.Load(), nil
+}
@@ -1,35 +1,80 @@
 package server
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/M1z23R/dr1ll/internal/httpheader"
 	"github.com/gorilla/websocket"
 )
 
 type Message struct {
-	Type      string            `json:"type"`
-	ID        string            `json:"id,omitempty"`
-	Subdomain string            `json:"subdomain,omitempty"`
-	Method    string            `json:"method,omitempty"`
-	Path      string            `json:"path,omitempty"`
-	Headers   map[string]string `json:"headers,omitempty"`
-	Body      string            `json:"body,omitempty"`
-	Status    int               `json:"status,omitempty"`
-	Error     string            `json:"error,omitempty"`
+	Type      string         `json:"type"`
+	ID        string         `json:"id,omitempty"`
+	Subdomain string         `json:"subdomain,omitempty"`
+	Method    string         `json:"method,omitempty"`
+	Path      string         `json:"path,omitempty"`
+	Headers   httpheader.Map `json:"headers,omitempty"`
+	Body      string         `json:"body,omitempty"`
+	Trailers  httpheader.Map `json:"trailers,omitempty"`
+	Status    int            `json:"status,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	// W3C trace context of the public request, for the upstream span.
+	TraceParent string `json:"traceparent,omitempty"`
+	TraceState  string `json:"tracestate,omitempty"`
 }
 
 type Client struct {
 	conn      *websocket.Conn
 	subdomain string
+	tokenName string // name of the auth token the tunnel connected with
 	send      chan Message
+	auth      *tunnelAuth
+	login     *loginPolicy
+	ipRules   *ipRules
+
+	remoteAddr  string
+	connectedAt time.Time
+	stats       tunnelStats
+	draining    atomic.Bool           // the client asked for no new requests
+	quota       atomic.Pointer[Quota] // quota of the token, refreshed on reload
+	sendMu      sync.Mutex            // orders requests against the drain acknowledgement
+}
+
+// Reasons enqueue refuses a request.
+var (
+	errTunnelDraining = errors.New("tunnel is draining")
+	errTunnelBusy     = errors.New("tunnel send buffer is full")
+)
+
+// enqueue queues a request for the tunnel unless it is draining. Checking
+// and sending under sendMu means no request can follow the drain_ack that
+// drainClient queues under the same lock.
+func (c *Client) enqueue(msg Message) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.draining.Load() {
+		return errTunnelDraining
+	}
+	select {
+	case c.send <- msg:
+		return nil
+	default:
+		return errTunnelBusy
+	}
 }
 
 type Server struct {
@@ -38,13 +83,37 @@ type Server struct {
 	upgrader          websocket.Upgrader
 	pendingRequests   map[string]chan Message
 	pendingRequestsMu sync.RWMutex
+	settingsMu        sync.RWMutex // guards the settings ApplySettings replaces
 	token             string
+	accounts          map[string]*account // by token name
+	usage             *usageTracker
 	domain            string
 	port              string
+	trustedProxies    []netip.Prefix
+	ipRules           *ipRules
+	limiter           *rateLimiter
+	oidc              *oidcGateway
+	metrics           *metrics
+	adminAddr         string
+	adminToken        string
+	accessLog         *accessLog
+	recentlyClosed    map[string]time.Time // subdomain -> disconnect time
+	tunnels           map[string]int       // token name -> open and opening tunnels
+	claiming          map[string]bool      // subdomains claimed by handshakes not yet registered
+	httpServer        *http.Server
+	adminServer       *http.Server
+	registry          Registry
+	nodeAddr          string // address other nodes reach this one at
+	clusterSecret     string // authenticates requests forwarded between nodes
+	store             *Store
+	certificate       atomic.Pointer[tls.Certificate] // served when TLS is enabled
+	draining          atomic.Bool                     // set once Shutdown starts
+	ctx               context.Context                 // done once Shutdown ends background loops
+	cancel            context.CancelFunc
 }
 
 func NewServer(token, domain, port string) *Server {
-	return &Server{
+	s := &Server{
 		clients: make(map[string]*Client),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
@@ -53,8 +122,205 @@ func NewServer(token, domain, port string) *Server {
 		},
 		pendingRequests: make(map[string]chan Message),
 		token:           token,
+		accounts:        make(map[string]*account),
+		recentlyClosed:  make(map[string]time.Time),
+		tunnels:         make(map[string]int),
+		claiming:        make(map[string]bool),
 		domain:          domain,
 		port:            port,
+		registry:        NewMemoryRegistry(),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.metrics = newMetrics(s)
+	return s
+}
+
+// SetTrustedProxies sets the proxies (CIDRs or IPs) whose X-Forwarded-* and
+// Forwarded headers are honored, e.g. a load balancer in front of the server.
+func (s *Server) SetTrustedProxies(proxies []string) error {
+	prefixes, err := parsePrefixes(proxies)
+	if err != nil {
+		return err
+	}
+	s.settingsMu.Lock()
+	s.trustedProxies = prefixes
+	s.settingsMu.Unlock()
+	return nil
+}
+
+// SetIPRules sets server-wide CIDR allow and deny lists, checked against the
+// real client address of every public request.
+func (s *Server) SetIPRules(allow, deny []string) error {
+	rules, err := newIPRules(allow, deny)
+	if err != nil {
+		return err
+	}
+	s.settingsMu.Lock()
+	s.ipRules = rules
+	s.settingsMu.Unlock()
+	return nil
+}
+
+// SetAdminAddr enables the admin listener, which serves Prometheus metrics
+// on /metrics and the admin API, at addr (e.g. "127.0.0.1:9091").
+func (s *Server) SetAdminAddr(addr string) {
+	s.adminAddr = addr
+}
+
+// SetAdminToken enables the admin API on the admin listener, protected by
+// token as a bearer credential.
+func (s *Server) SetAdminToken(token string) {
+	s.adminToken = token
+}
+
+// SetAccessLog writes a line for every public request to the file
+// described by cfg.
+func (s *Server) SetAccessLog(cfg AccessLogConfig) error {
+	accessLog, err := newAccessLog(cfg)
+	if err != nil {
+		return err
+	}
+	s.accessLog = accessLog
+	return nil
+}
+
+// SetRegistry shares tunnel ownership with other nodes through registry.
+// Public requests for a tunnel held by another node are forwarded to it,
+// which requires SetNodeAddr and SetClusterSecret on every node.
+func (s *Server) SetRegistry(registry Registry) {
+	s.registry = registry
+	go s.refreshLoop()
+}
+
+// SetNodeAddr sets the host:port other nodes reach this node's public
+// listener at, e.g. "10.0.0.5:9090". With the scheme the listener speaks,
+// it identifies the node in the registry.
+func (s *Server) SetNodeAddr(addr string) {
+	s.nodeAddr = addr
+}
+
+// SetClusterSecret sets the secret nodes use to authenticate requests they
+// forward to each other. Forwarded requests are trusted like those from a
+// trusted proxy.
+func (s *Server) SetClusterSecret(secret string) {
+	s.clusterSecret = secret
+}
+
+// SetStore enables tokens, reserved subdomains and custom domains managed in
+// st, and persists usage counters there.
+func (s *Server) SetStore(st *Store) error {
+	s.store = st
+	return s.SetUsageStore(st)
+}
+
+// SetLimits enables rate limiting and concurrency caps for public requests.
+// Rejected requests get a 429 with Retry-After.
+func (s *Server) SetLimits(limits Limits) {
+	limiter := newRateLimiter(limits)
+	go limiter.sweepLoop(s.ctx)
+
+	s.settingsMu.Lock()
+	old := s.limiter
+	s.limiter = limiter
+	s.settingsMu.Unlock()
+	old.stop()
+}
+
+// AddToken accepts an additional auth token, identified by name in limits
+// and usage reports, and restricted by quota.
+func (s *Server) AddToken(name, token string, quota Quota) error {
+	if name == "" || token == "" {
+		return fmt.Errorf("token name and value are required")
+	}
+	if name == DefaultTokenName {
+		return fmt.Errorf("token name %q is reserved", name)
+	}
+	s.settingsMu.Lock()
+	s.accounts[name] = &account{token: token, quota: quota}
+	s.settingsMu.Unlock()
+	return nil
+}
+
+// SetUsageStore enables usage accounting, persisted to store, so that token
+// quotas can be enforced across restarts.
+func (s *Server) SetUsageStore(store UsageStore) error {
+	tracker, err := newUsageTracker(store)
+	if err != nil {
+		return err
+	}
+	s.usage = tracker
+	go tracker.flushLoop(s.ctx)
+	return nil
+}
+
+// authenticate resolves the bearer token of a handshake to its name.
+func (s *Server) authenticate(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || scheme != "Bearer" {
+		return "", false
+	}
+	s.settingsMu.RLock()
+	if secureEqual(token, s.token) {
+		s.settingsMu.RUnlock()
+		return DefaultTokenName, true
+	}
+	for name, acct := range s.accounts {
+		if secureEqual(token, acct.token) {
+			s.settingsMu.RUnlock()
+			return name, true
+		}
+	}
+	s.settingsMu.RUnlock()
+	if s.store != nil {
+		stored, err := s.store.lookupToken(token)
+		if err != nil {
+			slog.Error("failed to look up token", "error", err)
+			return "", false
+		}
+		if stored != nil {
+			return stored.Name, true
+		}
+	}
+	return "", false
+}
+
+// quotaFor returns the quota of a token; the default token is unlimited.
+func (s *Server) quotaFor(tokenName string) Quota {
+	s.settingsMu.RLock()
+	acct, ok := s.accounts[tokenName]
+	s.settingsMu.RUnlock()
+	if ok {
+		return acct.quota
+	}
+	if s.store != nil {
+		quota, err := s.store.quotaOf(tokenName)
+		if err != nil {
+			slog.Error("failed to look up token quota", "token", tokenName, "error", err)
+		}
+		return quota
+	}
+	return Quota{}
+}
+
+// reserveTunnel takes one of a token's max tunnel slots for a handshake,
+// reporting false when all are taken. Zero means no limit. Counting and
+// taking happen under one lock so concurrent handshakes cannot overshoot;
+// the slot is held until releaseTunnel.
+func (s *Server) reserveTunnel(tokenName string, max int) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if max > 0 && s.tunnels[tokenName] >= max {
+		return false
+	}
+	s.tunnels[tokenName]++
+	return true
+}
+
+func (s *Server) releaseTunnel(tokenName string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.tunnels[tokenName]--; s.tunnels[tokenName] <= 0 {
+		delete(s.tunnels, tokenName)
 	}
 }
 
@@ -64,17 +330,37 @@ func (s *Server) generateSubdomain() string {
 	return hex.EncodeToString(bytes)
 }
 
-func (s *Server) isSubdomainAvailable(subdomain string) bool {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	_, exists := s.clients[subdomain]
-	return !exists
+// reserveSubdomain marks subdomain as taken on this node, reporting false if
+// a tunnel holds it or another handshake is claiming it. Checking and
+// marking happen under one lock so two handshakes cannot both get it.
+func (s *Server) reserveSubdomain(subdomain string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, exists := s.clients[subdomain]; exists || s.claiming[subdomain] {
+		return false
+	}
+	s.claiming[subdomain] = true
+	return true
+}
+
+func (s *Server) unreserveSubdomain(subdomain string) {
+	s.mutex.Lock()
+	delete(s.claiming, subdomain)
+	s.mutex.Unlock()
 }
 
 func (s *Server) registerClient(subdomain string, client *Client) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	s.clients[subdomain] = client
+	delete(s.claiming, subdomain)
+
+	s.metrics.addTunnel(subdomain)
+	s.metrics.connections.Inc()
+	if closedAt, ok := s.recentlyClosed[subdomain]; ok && time.Since(closedAt) < reconnectWindow {
+		s.metrics.reconnects.Inc()
+	}
+	delete(s.recentlyClosed, subdomain)
 }
 
 func (s *Server) unregisterClient(subdomain string) {
@@ -83,6 +369,14 @@ func (s *Server) unregisterClient(subdomain string) {
 	if client, ok := s.clients[subdomain]; ok {
 		close(client.send)
 		delete(s.clients, subdomain)
+		s.metrics.forgetTunnel(subdomain)
+
+		for name, closedAt := range s.recentlyClosed {
+			if time.Since(closedAt) >= reconnectWindow {
+				delete(s.recentlyClosed, name)
+			}
+		}
+		s.recentlyClosed[subdomain] = time.Now()
 	}
 }
 
@@ -94,42 +388,117 @@ func (s *Server) getClient(subdomain string) (*Client, bool) {
 }
 
 func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
-	if token != "Bearer "+s.token {
+	if s.draining.Load() {
+		shuttingDown(w)
+		return
+	}
+
+	tokenName, ok := s.authenticate(r)
+	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
+	quota := s.quotaFor(tokenName)
+	if !s.reserveTunnel(tokenName, quota.MaxTunnels) {
+		http.Error(w, fmt.Sprintf("Tunnel limit of %d reached", quota.MaxTunnels), http.StatusForbidden)
+		return
+	}
+	defer s.releaseTunnel(tokenName)
+	if _, over := s.usage.exceeded(tokenName, quota); over {
+		http.Error(w, "Quota exceeded", http.StatusForbidden)
+		return
+	}
+
+	auth, err := parseTunnelAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	login, err := parseLoginPolicy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if login != nil && s.oidc == nil {
+		http.Error(w, "OIDC login is not configured on this server", http.StatusBadRequest)
+		return
+	}
+
+	ipRules, err := parseTunnelIPRules(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade failed: %v", err)
+		slog.Warn("WebSocket upgrade failed", "error", err)
+		return
+	}
+
+	// A probe, e.g. from `dr1ll config doctor`, only checks that a tunnel
+	// could be opened.
+	if r.URL.Query().Get("probe") == "1" {
+		slog.Debug("handshake probe succeeded", "token", tokenName)
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "probe ok"))
+		conn.Close()
 		return
 	}
 
 	requestedSubdomain := r.URL.Query().Get("subdomain")
 	var subdomain string
-	
+
 	if requestedSubdomain != "" {
-		if s.isSubdomainAvailable(requestedSubdomain) {
+		claimed, err := s.claimSubdomain(requestedSubdomain, tokenName)
+		if err != nil {
+			slog.Error("failed to claim subdomain", "tunnel", requestedSubdomain, "error", err)
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "Tunnel registry unavailable"))
+			conn.Close()
+			return
+		}
+		if claimed {
 			subdomain = requestedSubdomain
-			log.Printf("Client requested and assigned subdomain: %s.%s", subdomain, s.domain)
+			slog.Info("client connected with requested subdomain", "tunnel", subdomain, "token", tokenName)
 		} else {
-			log.Printf("Requested subdomain '%s' not available, rejecting connection", requestedSubdomain)
+			slog.Info("requested subdomain not available, rejecting connection", "tunnel", requestedSubdomain, "token", tokenName)
 			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseUnsupportedData, fmt.Sprintf("Subdomain '%s' is not available", requestedSubdomain)))
 			conn.Close()
 			return
 		}
 	} else {
-		subdomain = s.generateSubdomain()
-		log.Printf("Client connected with generated subdomain: %s.%s", subdomain, s.domain)
+		for {
+			subdomain = s.generateSubdomain()
+			claimed, err := s.claimSubdomain(subdomain, tokenName)
+			if err != nil {
+				slog.Error("failed to claim subdomain", "tunnel", subdomain, "error", err)
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "Tunnel registry unavailable"))
+				conn.Close()
+				return
+			}
+			if claimed {
+				break
+			}
+		}
+		slog.Info("client connected with generated subdomain", "tunnel", subdomain, "token", tokenName)
 	}
 
 	client := &Client{
 		conn:      conn,
 		subdomain: subdomain,
+		tokenName: tokenName,
 		send:      make(chan Message, 256),
+		auth:      auth,
+		login:     login,
+		ipRules:   ipRules,
+
+		remoteAddr:  s.forwardedFor(r).clientIP,
+		connectedAt: time.Now(),
 	}
+	client.quota.Store(&quota)
 
+	defer s.releaseSubdomain(subdomain)
 	s.registerClient(subdomain, client)
 	defer s.unregisterClient(subdomain)
 
@@ -139,11 +508,11 @@ func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	go s.writePump(client)
-	
+
 	select {
 	case client.send <- assignMsg:
 	default:
-		log.Printf("Failed to send initial subdomain assignment: client send channel full")
+		slog.Warn("failed to send subdomain assignment: client send channel full", "tunnel", subdomain)
 		return
 	}
 
@@ -156,8 +525,8 @@ func (s *Server) readPump(client *Client) {
 	for {
 		var msg Message
 		if err := client.conn.ReadJSON(&msg); err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				slog.Warn("WebSocket error", "tunnel", client.subdomain, "error", err)
 			}
 			break
 		}
@@ -165,6 +534,8 @@ func (s *Server) readPump(client *Client) {
 		switch msg.Type {
 		case "http_response":
 			s.handleHTTPResponse(msg)
+		case "drain":
+			s.drainClient(client)
 		}
 	}
 }
@@ -181,13 +552,30 @@ func (s *Server) writePump(client *Client) {
 			}
 
 			if err := client.conn.WriteJSON(msg); err != nil {
-				log.Printf("Failed to write message: %v", err)
+				slog.Warn("failed to write message", "tunnel", client.subdomain, "error", err)
 				return
 			}
 		}
 	}
 }
 
+// drainClient stops routing new requests to a tunnel that is shutting down.
+// Marking it draining and queueing the acknowledgement happen under sendMu,
+// so every request enqueued before is ahead of the acknowledgement and none
+// follows it.
+func (s *Server) drainClient(client *Client) {
+	client.sendMu.Lock()
+	defer client.sendMu.Unlock()
+	client.draining.Store(true)
+	slog.Info("tunnel draining", "tunnel", client.subdomain)
+
+	select {
+	case client.send <- Message{Type: "drain_ack"}:
+	default:
+		slog.Warn("failed to acknowledge drain: client send channel full", "tunnel", client.subdomain)
+	}
+}
+
 func (s *Server) handleHTTPResponse(msg Message) {
 	s.pendingRequestsMu.RLock()
 	ch, ok := s.pendingRequests[msg.ID]
@@ -195,40 +583,173 @@ func (s *Server) handleHTTPResponse(msg Message) {
 	if ok {
 		ch <- msg
 	} else {
-		log.Printf("No pending request for ID %s", msg.ID)
+		slog.Warn("no pending request for response", "request_id", msg.ID)
 	}
 }
 
-
 func (s *Server) HandleHTTPRequest(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w}
+	w = rec
+	fwd := s.forwardedFor(r)
+	ctx, span := startRequestSpan(r, fwd)
+	tunnel := noTunnel
+	var user, requestID string
+	defer func() {
+		endRequestSpan(span, tunnel, requestID, rec.Status())
+		s.metrics.observeRequest(tunnel, rec.Status())
+		slog.Debug("public request handled",
+			"tunnel", tunnel, "request_id", requestID, "user", user,
+			"method", r.Method, "path", r.URL.Path, "status", rec.Status(), "duration", time.Since(start))
+		if s.accessLog != nil {
+			s.accessLog.write(accessEntry{
+				Time:      start,
+				Host:      r.Host,
+				Tunnel:    tunnel,
+				ClientIP:  fwd.clientIP,
+				User:      user,
+				Method:    r.Method,
+				URI:       r.URL.RequestURI(),
+				Proto:     r.Proto,
+				Status:    rec.Status(),
+				Bytes:     rec.bytes,
+				Duration:  float64(time.Since(start).Microseconds()) / 1000,
+				Referer:   r.Referer(),
+				UserAgent: r.UserAgent(),
+				RequestID: requestID,
+			})
+		}
+	}()
+
+	if s.oidc != nil && s.oidc.isCallback(r) {
+		s.oidc.handleCallback(w, r)
+		return
+	}
+
 	host := r.Host
 	if !strings.Contains(host, ".") {
 		http.Error(w, "Invalid subdomain", http.StatusBadRequest)
 		return
 	}
 
-	subdomain := strings.Split(host, ".")[0]
+	subdomain, domain, err := s.route(host)
+	if err != nil {
+		slog.Error("failed to look up custom domain", "host", host, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if s.draining.Load() {
+		s.metrics.reject(rejectShuttingDown)
+		shuttingDown(w)
+		return
+	}
+
+	if !s.serverIPRules().permits(fwd.clientIP) {
+		s.metrics.reject(rejectForbidden)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	client, exists := s.getClient(subdomain)
 	if !exists {
+		if s.forwardToOwner(ctx, w, r, subdomain, fwd) {
+			return
+		}
+		s.metrics.reject(rejectNotFound)
+		http.Error(w, "Tunnel not found", http.StatusNotFound)
+		return
+	}
+	if domain != nil && domain.Token != client.tokenName {
+		s.metrics.reject(rejectNotFound)
 		http.Error(w, "Tunnel not found", http.StatusNotFound)
 		return
 	}
+	tunnel = subdomain
+
+	if client.draining.Load() {
+		s.metrics.reject(rejectShuttingDown)
+		w.Header().Set("Retry-After", shutdownRetryAfter)
+		http.Error(w, "Tunnel is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !client.ipRules.permits(fwd.clientIP) {
+		s.metrics.reject(rejectForbidden)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	done, retryAfter, ok := s.rateLimiter().admit(subdomain, client.tokenName, fwd.clientIP)
+	if !ok {
+		s.metrics.reject(rejectRateLimited)
+		tooManyRequests(w, retryAfter)
+		return
+	}
+	defer done()
+
+	if resetIn, over := s.usage.exceeded(client.tokenName, *client.quota.Load()); over {
+		s.metrics.reject(rejectQuota)
+		w.Header().Set("Retry-After", strconv.Itoa(int(resetIn.Seconds())+1))
+		http.Error(w, "Quota exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	if client.auth != nil {
+		var ok bool
+		if user, ok = client.auth.check(r); !ok {
+			client.auth.challenge(w)
+			return
+		}
+	}
+
+	var id *identity
+	if client.login != nil {
+		if id = s.oidc.authenticate(w, r, fwd, client.login); id == nil {
+			return
+		}
+		user = id.name()
+	}
+
+	client.stats.requests.Add(1)
+	client.stats.inFlight.Add(1)
+	defer client.stats.inFlight.Add(-1)
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
+		client.stats.errors.Add(1)
 		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
 		return
 	}
 
-	headers := make(map[string]string)
-	for name, values := range r.Header {
-		if len(values) > 0 {
-			headers[name] = values[0]
+	// The body has been read in full, so the tunnel carries it with an
+	// implicit length; framing headers from this hop must not leak through.
+	reqHeader := r.Header.Clone()
+	httpheader.RemoveHopByHop(reqHeader)
+	reqHeader.Del("Content-Length")
+	reqHeader.Del(nodeSecretHeader)
+	if client.auth != nil {
+		// The credentials were for the tunnel, not the local app.
+		reqHeader.Del("Authorization")
+	}
+	if client.login != nil {
+		stripSessionCookie(reqHeader)
+	}
+	headers := httpheader.ToMap(reqHeader)
+	fwd.apply(headers)
+	if user != "" {
+		headers.Set("X-Forwarded-User", user)
+	}
+	if id != nil {
+		if id.Email != "" {
+			headers.Set("X-Forwarded-Email", id.Email)
+		}
+		if len(id.Groups) > 0 {
+			headers.Set("X-Forwarded-Groups", strings.Join(id.Groups, ","))
 		}
 	}
 
-	requestID := s.generateSubdomain()
+	requestID = s.generateSubdomain()
 	responseChan := make(chan Message, 1)
 
 	s.pendingRequestsMu.Lock()
@@ -242,40 +763,122 @@ func (s *Server) HandleHTTPRequest(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	msg := Message{
-		Type:    "http_request",
-		ID:      requestID,
-		Method:  r.Method,
-		Path:    r.URL.Path,
-		Headers: headers,
-		Body:    string(body),
+		Type:     "http_request",
+		ID:       requestID,
+		Method:   r.Method,
+		Path:     r.URL.RequestURI(),
+		Headers:  headers,
+		Body:     string(body),
+		Trailers: httpheader.ToMap(r.Trailer),
 	}
+	injectTrace(ctx, &msg)
 
-	select {
-	case client.send <- msg:
+	sentAt := time.Now()
+	switch err := client.enqueue(msg); err {
+	case nil:
 		select {
 		case resp := <-responseChan:
-			for key, value := range resp.Headers {
-				w.Header().Set(key, value)
-			}
-			w.WriteHeader(resp.Status)
-			w.Write([]byte(resp.Body))
+			s.metrics.observeRoundTrip(subdomain, time.Since(sentAt), len(body), len(resp.Body))
+			client.stats.bytesIn.Add(int64(len(body)))
+			client.stats.bytesOut.Add(int64(len(resp.Body)))
+			writeResponse(w, r, resp)
+			s.usage.record(client.tokenName, int64(len(body)+len(resp.Body)))
 		case <-time.After(30 * time.Second):
+			client.stats.errors.Add(1)
+			s.metrics.reject(rejectTimeout)
 			http.Error(w, "Client response timeout", http.StatusGatewayTimeout)
 		case <-r.Context().Done():
+			s.metrics.reject(rejectCancelled)
 			http.Error(w, "Request cancelled", http.StatusRequestTimeout)
 		}
+	case errTunnelDraining:
+		s.metrics.reject(rejectShuttingDown)
+		w.Header().Set("Retry-After", shutdownRetryAfter)
+		http.Error(w, "Tunnel is shutting down", http.StatusServiceUnavailable)
 	default:
+		client.stats.errors.Add(1)
+		s.metrics.reject(rejectBusy)
 		http.Error(w, "Tunnel is busy", http.StatusServiceUnavailable)
 	}
 }
 
-func (s *Server) Start() error {
-	http.HandleFunc("/ws", s.HandleWebSocket)
-	http.HandleFunc("/", s.HandleHTTPRequest)
+// writeResponse writes a tunneled response, stripping hop-by-hop headers and
+// re-establishing framing: a Content-Length matching the body, or chunked
+// encoding when the upstream sent trailers.
+func writeResponse(w http.ResponseWriter, r *http.Request, resp Message) {
+	header := httpheader.FromMap(resp.Headers)
+	httpheader.RemoveHopByHop(header)
+
+	// HEAD and 304 responses describe a body that is not sent, so the
+	// upstream Content-Length is kept as-is.
+	if r.Method != http.MethodHead && resp.Status != http.StatusNotModified {
+		header.Del("Content-Length")
+		if len(resp.Trailers) == 0 && resp.Status >= 200 && resp.Status != http.StatusNoContent {
+			header.Set("Content-Length", strconv.Itoa(len(resp.Body)))
+		}
+	}
+
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	for name := range resp.Trailers {
+		w.Header().Add("Trailer", name)
+	}
+
+	w.WriteHeader(resp.Status)
+	w.Write([]byte(resp.Body))
+
+	for name, values := range resp.Trailers {
+		w.Header()[name] = values
+	}
+}
 
-	log.Printf("Tunnel server starting on :%s", s.port)
-	log.Printf("WebSocket endpoint: wss://%s:%s/ws", s.domain, s.port)
-	log.Printf("HTTP tunnels: https://*.%s:%s", s.domain, s.port)
+// Handler returns the handler serving tunnel WebSockets on /ws and public
+// requests on every other path.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.HandleWebSocket)
+	mux.HandleFunc("/", s.HandleHTTPRequest)
+	return mux
+}
+
+// Start serves until the server fails or Shutdown is called, in which case
+// it returns nil.
+func (s *Server) Start() error {
+	s.mutex.Lock()
+	s.httpServer = &http.Server{Addr: ":" + s.port, Handler: s.Handler()}
+	useTLS := s.certificate.Load() != nil
+	if useTLS {
+		s.httpServer.TLSConfig = &tls.Config{GetCertificate: s.getCertificate}
+	}
+	if s.adminAddr != "" {
+		s.adminServer = &http.Server{Addr: s.adminAddr, Handler: s.adminHandler()}
+	}
+	httpServer, adminServer := s.httpServer, s.adminServer
+	s.mutex.Unlock()
+
+	slog.Info("tunnel server starting",
+		"port", s.port,
+		"websocket", fmt.Sprintf("wss://%s:%s/ws", s.domain, s.port),
+		"tunnels", fmt.Sprintf("https://*.%s:%s", s.domain, s.port))
+
+	if adminServer != nil {
+		go func() {
+			slog.Info("admin listener starting", "addr", s.adminAddr)
+			if err := adminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("admin listener failed", "error", err)
+			}
+		}()
+	}
 
-	return http.ListenAndServe(":"+s.port, nil)
-}
\ No newline at end of file
+	var err error
+	if useTLS {
+		err = httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
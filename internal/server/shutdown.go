@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// shutdownRetryAfter is the Retry-After hint, in seconds, given to public
+// requests refused while the server drains.
+const shutdownRetryAfter = "5"
+
+// Shutdown stops the server gracefully. New public requests and tunnels are
+// refused with a 503, connected clients are told the server is going away so
+// they can reconnect elsewhere, and requests already in flight are given
+// until ctx is done to complete. Tunnels are then closed, background loops
+// are stopped, usage is flushed and the access log is closed. It returns
+// ctx's error if requests were still pending when the deadline passed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+
+	s.pendingRequestsMu.RLock()
+	pending := len(s.pendingRequests)
+	s.pendingRequestsMu.RUnlock()
+	slog.Info("shutting down, draining requests", "pending", pending)
+
+	s.broadcast(Message{Type: "server_shutdown"})
+
+	s.mutex.RLock()
+	httpServer, adminServer := s.httpServer, s.adminServer
+	s.mutex.RUnlock()
+
+	// Hijacked WebSocket connections are not tracked by http.Server, so
+	// this only waits for public requests, which in turn wait on their
+	// tunnel responses.
+	var err error
+	if httpServer != nil {
+		err = httpServer.Shutdown(ctx)
+		if err != nil {
+			slog.Warn("drain timeout reached, abandoning pending requests", "error", err)
+		}
+	}
+
+	s.closeTunnels(websocket.CloseGoingAway, "server shutting down")
+
+	if adminServer != nil {
+		adminServer.Close()
+	}
+	s.cancel()
+	if s.usage != nil {
+		if err := s.usage.flush(); err != nil {
+			slog.Error("failed to save usage", "error", err)
+		}
+	}
+	if s.accessLog != nil {
+		s.accessLog.Close()
+	}
+
+	slog.Info("server stopped")
+	return err
+}
+
+// broadcast queues msg for every connected tunnel, skipping those whose
+// send buffer is full.
+func (s *Server) broadcast(msg Message) {
+	// Holding the lock keeps unregisterClient from closing a send channel
+	// mid-send.
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, client := range s.clients {
+		select {
+		case client.send <- msg:
+		default:
+			slog.Warn("failed to notify tunnel: client send channel full", "tunnel", client.subdomain, "type", msg.Type)
+		}
+	}
+}
+
+// closeTunnels closes every tunnel connection with the given close code.
+func (s *Server) closeTunnels(code int, reason string) {
+	s.mutex.RLock()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mutex.RUnlock()
+
+	closeMsg := websocket.FormatCloseMessage(code, reason)
+	for _, client := range clients {
+		client.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		client.conn.Close()
+	}
+}
+
+// shuttingDown refuses a request while the server drains.
+func shuttingDown(w http.ResponseWriter) {
+	w.Header().Set("Connection", "close")
+	w.Header().Set("Retry-After", shutdownRetryAfter)
+	http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,514 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// migrations upgrade the schema one version at a time; the schema version is
+// kept in SQLite's user_version. Append new migrations, never edit old ones.
+var migrations = []string{
+	// 1: initial schema.
+	`CREATE TABLE users (
+		name       TEXT PRIMARY KEY,
+		email      TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL
+	);
+	CREATE TABLE tokens (
+		name               TEXT PRIMARY KEY,
+		hash               TEXT NOT NULL UNIQUE,
+		owner              TEXT REFERENCES users(name) ON DELETE CASCADE,
+		bytes_per_day      INTEGER NOT NULL DEFAULT 0,
+		requests_per_month INTEGER NOT NULL DEFAULT 0,
+		max_tunnels        INTEGER NOT NULL DEFAULT 0,
+		created_at         INTEGER NOT NULL
+	);
+	CREATE TABLE reservations (
+		subdomain  TEXT PRIMARY KEY,
+		token      TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE TABLE domains (
+		host       TEXT PRIMARY KEY,
+		subdomain  TEXT NOT NULL,
+		token      TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE TABLE usage (
+		token               TEXT PRIMARY KEY,
+		day                 TEXT NOT NULL DEFAULT '',
+		bytes_today         INTEGER NOT NULL DEFAULT 0,
+		month               TEXT NOT NULL DEFAULT '',
+		requests_this_month INTEGER NOT NULL DEFAULT 0,
+		bytes_total         INTEGER NOT NULL DEFAULT 0,
+		requests_total      INTEGER NOT NULL DEFAULT 0
+	);`,
+}
+
+// ErrNotFound is returned when removing or looking up a record that does not
+// exist.
+var ErrNotFound = errors.New("not found")
+
+// User is a person owning tokens.
+type User struct {
+	Name      string
+	Email     string
+	CreatedAt time.Time
+}
+
+// StoredToken describes a token kept in the store. Only a hash of its value
+// is stored, so the value itself is shown once, when created.
+type StoredToken struct {
+	Name      string
+	Owner     string
+	Quota     Quota
+	CreatedAt time.Time
+}
+
+// Reservation keeps a subdomain for tunnels of one token.
+type Reservation struct {
+	Subdomain string
+	Token     string
+	CreatedAt time.Time
+}
+
+// CustomDomain routes public requests for Host to a token's tunnel on
+// Subdomain.
+type CustomDomain struct {
+	Host      string
+	Subdomain string
+	Token     string
+	CreatedAt time.Time
+}
+
+// Store persists users, tokens, reserved subdomains, custom domains and usage
+// counters in an SQLite database. The server and CLI may have it open at the
+// same time.
+type Store struct {
+	db *sql.DB
+}
+
+// OpenStore opens the database at path, creating it and applying any pending
+// migrations.
+func OpenStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	// The store holds token hashes; SQLite gives its journal files the
+	// same permissions as the database file.
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+
+	st := &Store{db: db}
+	if err := st.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return st, nil
+}
+
+func (st *Store) migrate() error {
+	var version int
+	if err := st.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read store version: %w", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("store version %d is newer than this binary supports (%d)", version, len(migrations))
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := st.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to migrate store to version %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to migrate store to version %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
+
+func (st *Store) Close() error {
+	return st.db.Close()
+}
+
+func (st *Store) AddUser(name, email string) error {
+	if name == "" {
+		return fmt.Errorf("user name is required")
+	}
+	_, err := st.db.Exec("INSERT INTO users (name, email, created_at) VALUES (?, ?, ?)",
+		name, email, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to add user: %w", err)
+	}
+	return nil
+}
+
+func (st *Store) Users() ([]User, error) {
+	rows, err := st.db.Query("SELECT name, email, created_at FROM users ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		var created int64
+		if err := rows.Scan(&u.Name, &u.Email, &created); err != nil {
+			return nil, err
+		}
+		u.CreatedAt = time.Unix(created, 0)
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// RemoveUser deletes a user along with their tokens and the reservations and
+// custom domains of those.
+func (st *Store) RemoveUser(name string) error {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"reservations", "domains"} {
+		_, err := tx.Exec("DELETE FROM "+table+" WHERE token IN (SELECT name FROM tokens WHERE owner = ?)", name)
+		if err != nil {
+			return err
+		}
+	}
+	res, err := tx.Exec("DELETE FROM users WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%q: %w", name, ErrNotFound)
+	}
+	return tx.Commit()
+}
+
+// CreateToken stores a new token and returns its value, which cannot be
+// retrieved again. owner may be empty.
+func (st *Store) CreateToken(name, owner string, quota Quota) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("token name is required")
+	}
+	if name == DefaultTokenName {
+		return "", fmt.Errorf("token name %q is reserved", name)
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	value := hex.EncodeToString(secret)
+
+	var ownerValue any
+	if owner != "" {
+		ownerValue = owner
+	}
+	_, err := st.db.Exec(`INSERT INTO tokens
+		(name, hash, owner, bytes_per_day, requests_per_month, max_tunnels, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		name, hashToken(value), ownerValue, quota.BytesPerDay, quota.RequestsPerMonth, quota.MaxTunnels, time.Now().Unix())
+	if err != nil {
+		return "", fmt.Errorf("failed to create token: %w", err)
+	}
+	return value, nil
+}
+
+func (st *Store) Tokens() ([]StoredToken, error) {
+	rows, err := st.db.Query(`SELECT name, COALESCE(owner, ''), bytes_per_day, requests_per_month, max_tunnels, created_at
+		FROM tokens ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []StoredToken
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeToken deletes a token with its reservations and custom domains.
+// Tunnels already connected with it stay up until they reconnect.
+func (st *Store) RevokeToken(name string) error {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM tokens WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%q: %w", name, ErrNotFound)
+	}
+	if _, err := tx.Exec("DELETE FROM reservations WHERE token = ?", name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM domains WHERE token = ?", name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lookupToken finds the stored token with the given value, or nil.
+func (st *Store) lookupToken(value string) (*StoredToken, error) {
+	row := st.db.QueryRow(`SELECT name, COALESCE(owner, ''), bytes_per_day, requests_per_month, max_tunnels, created_at
+		FROM tokens WHERE hash = ?`, hashToken(value))
+	t, err := scanToken(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return t, err
+}
+
+// quotaOf returns the quota of the stored token called name; unknown tokens
+// are unlimited.
+func (st *Store) quotaOf(name string) (Quota, error) {
+	var q Quota
+	err := st.db.QueryRow("SELECT bytes_per_day, requests_per_month, max_tunnels FROM tokens WHERE name = ?", name).
+		Scan(&q.BytesPerDay, &q.RequestsPerMonth, &q.MaxTunnels)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Quota{}, nil
+	}
+	return q, err
+}
+
+// Reserve keeps subdomain for tunnels connecting with token.
+func (st *Store) Reserve(subdomain, token string) error {
+	if subdomain == "" || strings.Contains(subdomain, ".") {
+		return fmt.Errorf("invalid subdomain %q", subdomain)
+	}
+	_, err := st.db.Exec("INSERT INTO reservations (subdomain, token, created_at) VALUES (?, ?, ?)",
+		subdomain, token, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to reserve subdomain: %w", err)
+	}
+	return nil
+}
+
+func (st *Store) Reservations() ([]Reservation, error) {
+	rows, err := st.db.Query("SELECT subdomain, token, created_at FROM reservations ORDER BY subdomain")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reservations []Reservation
+	for rows.Next() {
+		var r Reservation
+		var created int64
+		if err := rows.Scan(&r.Subdomain, &r.Token, &created); err != nil {
+			return nil, err
+		}
+		r.CreatedAt = time.Unix(created, 0)
+		reservations = append(reservations, r)
+	}
+	return reservations, rows.Err()
+}
+
+func (st *Store) Unreserve(subdomain string) error {
+	return st.remove("DELETE FROM reservations WHERE subdomain = ?", subdomain)
+}
+
+// reservedBy returns the token holding a reservation on subdomain, or "".
+func (st *Store) reservedBy(subdomain string) (string, error) {
+	var token string
+	err := st.db.QueryRow("SELECT token FROM reservations WHERE subdomain = ?", subdomain).Scan(&token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return token, err
+}
+
+// AddDomain routes host to the tunnel on subdomain, as long as that tunnel
+// connected with token.
+func (st *Store) AddDomain(host, subdomain, token string) error {
+	host = strings.ToLower(host)
+	if host == "" || subdomain == "" {
+		return fmt.Errorf("host and subdomain are required")
+	}
+	_, err := st.db.Exec("INSERT INTO domains (host, subdomain, token, created_at) VALUES (?, ?, ?, ?)",
+		host, subdomain, token, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to add domain: %w", err)
+	}
+	return nil
+}
+
+func (st *Store) Domains() ([]CustomDomain, error) {
+	rows, err := st.db.Query("SELECT host, subdomain, token, created_at FROM domains ORDER BY host")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []CustomDomain
+	for rows.Next() {
+		var d CustomDomain
+		var created int64
+		if err := rows.Scan(&d.Host, &d.Subdomain, &d.Token, &created); err != nil {
+			return nil, err
+		}
+		d.CreatedAt = time.Unix(created, 0)
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
+func (st *Store) RemoveDomain(host string) error {
+	return st.remove("DELETE FROM domains WHERE host = ?", strings.ToLower(host))
+}
+
+// domainFor returns the custom domain registered for host, or nil.
+func (st *Store) domainFor(host string) (*CustomDomain, error) {
+	d := CustomDomain{Host: strings.ToLower(host)}
+	var created int64
+	err := st.db.QueryRow("SELECT subdomain, token, created_at FROM domains WHERE host = ?", d.Host).
+		Scan(&d.Subdomain, &d.Token, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.CreatedAt = time.Unix(created, 0)
+	return &d, nil
+}
+
+func (st *Store) LoadUsage() (map[string]*Usage, error) {
+	rows, err := st.db.Query(`SELECT token, day, bytes_today, month, requests_this_month, bytes_total, requests_total
+		FROM usage`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := make(map[string]*Usage)
+	for rows.Next() {
+		var token string
+		u := &Usage{}
+		if err := rows.Scan(&token, &u.Day, &u.BytesToday, &u.Month, &u.RequestsThisMonth, &u.BytesTotal, &u.RequestsTotal); err != nil {
+			return nil, err
+		}
+		usage[token] = u
+	}
+	return usage, rows.Err()
+}
+
+func (st *Store) SaveUsage(usage map[string]*Usage) error {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for token, u := range usage {
+		_, err := tx.Exec(`INSERT INTO usage
+			(token, day, bytes_today, month, requests_this_month, bytes_total, requests_total)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (token) DO UPDATE SET
+				day = excluded.day, bytes_today = excluded.bytes_today,
+				month = excluded.month, requests_this_month = excluded.requests_this_month,
+				bytes_total = excluded.bytes_total, requests_total = excluded.requests_total`,
+			token, u.Day, u.BytesToday, u.Month, u.RequestsThisMonth, u.BytesTotal, u.RequestsTotal)
+		if err != nil {
+			return fmt.Errorf("failed to save usage: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// remove runs a single-row delete, reporting ErrNotFound if nothing matched.
+func (st *Store) remove(query, key string) error {
+	res, err := st.db.Exec(query, key)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%q: %w", key, ErrNotFound)
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanToken(row rowScanner) (*StoredToken, error) {
+	var t StoredToken
+	var created int64
+	if err := row.Scan(&t.Name, &t.Owner, &t.Quota.BytesPerDay, &t.Quota.RequestsPerMonth, &t.Quota.MaxTunnels, &created); err != nil {
+		return nil, err
+	}
+	t.CreatedAt = time.Unix(created, 0)
+	return &t, nil
+}
+
+// hashToken returns the stored form of a token value.
+func hashToken(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// route works out which tunnel subdomain serves host. Hosts outside the
+// server's domain are looked up as custom domains, which are returned so the
+// caller can check the tunnel's token.
+func (s *Server) route(host string) (string, *CustomDomain, error) {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	hostname = strings.ToLower(hostname)
+
+	if s.store != nil && hostname != s.domain && !strings.HasSuffix(hostname, "."+s.domain) {
+		domain, err := s.store.domainFor(hostname)
+		if err != nil {
+			return "", nil, err
+		}
+		if domain != nil {
+			return domain.Subdomain, domain, nil
+		}
+	}
+	return strings.Split(hostname, ".")[0], nil, nil
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTestStore(t *testing.T, path string) *Store {
+	t.Helper()
+	st, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func storeVersion(t *testing.T, st *Store) int {
+	t.Helper()
+	var version int
+	if err := st.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestStoreMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "dr1ll.db")
+
+	st := openTestStore(t, path)
+	if got := storeVersion(t, st); got != len(migrations) {
+		t.Fatalf("new store version = %d, want %d", got, len(migrations))
+	}
+	if err := st.AddUser("alice", "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+
+	// A later migration is applied on open and existing data is kept.
+	saved := migrations
+	migrations = append(append([]string(nil), migrations...), `ALTER TABLE users ADD COLUMN team TEXT NOT NULL DEFAULT ''`)
+	defer func() { migrations = saved }()
+
+	st = openTestStore(t, path)
+	if got := storeVersion(t, st); got != len(migrations) {
+		t.Errorf("upgraded store version = %d, want %d", got, len(migrations))
+	}
+	var team string
+	if err := st.db.QueryRow("SELECT team FROM users WHERE name = 'alice'").Scan(&team); err != nil {
+		t.Errorf("migration was not applied: %v", err)
+	}
+	st.Close()
+
+	// A binary that knows fewer migrations refuses the newer store.
+	migrations = saved
+	if _, err := OpenStore(path); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("opening a newer store: error = %v", err)
+	}
+}
+
+func TestStoreFailedMigrationRollsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dr1ll.db")
+	openTestStore(t, path).Close()
+
+	saved := migrations
+	migrations = append(append([]string(nil), migrations...),
+		`CREATE TABLE extra (id INTEGER); SELECT * FROM missing_table`)
+	defer func() { migrations = saved }()
+
+	if _, err := OpenStore(path); err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+
+	migrations = saved
+	st := openTestStore(t, path)
+	if got := storeVersion(t, st); got != len(migrations) {
+		t.Errorf("version after failed migration = %d, want %d", got, len(migrations))
+	}
+	var n int
+	st.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'extra'").Scan(&n)
+	if n != 0 {
+		t.Error("failed migration left a table behind")
+	}
+}
+
+func TestStoreTokens(t *testing.T) {
+	st := openTestStore(t, filepath.Join(t.TempDir(), "dr1ll.db"))
+	if err := st.AddUser("alice", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	quota := Quota{BytesPerDay: 1 << 20, RequestsPerMonth: 1000, MaxTunnels: 2}
+	value, err := st.CreateToken("ci", "alice", quota)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		tokName string
+		owner   string
+		wantErr bool
+	}{
+		{name: "duplicate name", tokName: "ci", wantErr: true},
+		{name: "reserved name", tokName: DefaultTokenName, wantErr: true},
+		{name: "empty name", tokName: "", wantErr: true},
+		{name: "unknown owner", tokName: "other", owner: "bob", wantErr: true},
+		{name: "no owner", tokName: "shared"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := st.CreateToken(tt.tokName, tt.owner, Quota{}); (err != nil) != tt.wantErr {
+				t.Errorf("CreateToken(%q, %q) error = %v, wantErr %v", tt.tokName, tt.owner, err, tt.wantErr)
+			}
+		})
+	}
+
+	found, err := st.lookupToken(value)
+	if err != nil || found == nil || found.Name != "ci" || found.Owner != "alice" || found.Quota != quota {
+		t.Errorf("lookupToken = %+v, %v", found, err)
+	}
+	if found, _ := st.lookupToken("wrong"); found != nil {
+		t.Errorf("lookupToken(wrong) = %+v, want nil", found)
+	}
+	var stored string
+	st.db.QueryRow("SELECT hash FROM tokens WHERE name = 'ci'").Scan(&stored)
+	if stored == value || stored != hashToken(value) {
+		t.Error("token value is not stored hashed")
+	}
+	if q, _ := st.quotaOf("ci"); q != quota {
+		t.Errorf("quotaOf = %+v, want %+v", q, quota)
+	}
+
+	if err := st.Reserve("ci-app", "ci"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.AddDomain("App.Example.org", "ci-app", "ci"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the owner takes its tokens, reservations and domains along.
+	if err := st.RemoveUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := st.lookupToken(value); found != nil {
+		t.Error("token survived its owner")
+	}
+	if by, _ := st.reservedBy("ci-app"); by != "" {
+		t.Error("reservation survived its token")
+	}
+	if d, _ := st.domainFor("app.example.org"); d != nil {
+		t.Error("custom domain survived its token")
+	}
+	if err := st.RemoveUser("alice"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RemoveUser twice: error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStoreReservationsAndDomains(t *testing.T) {
+	st := openTestStore(t, filepath.Join(t.TempDir(), "dr1ll.db"))
+
+	tests := []struct {
+		name      string
+		subdomain string
+		wantErr   bool
+	}{
+		{name: "valid", subdomain: "app"},
+		{name: "taken", subdomain: "app", wantErr: true},
+		{name: "empty", subdomain: "", wantErr: true},
+		{name: "dotted", subdomain: "a.b", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := st.Reserve(tt.subdomain, "ci"); (err != nil) != tt.wantErr {
+				t.Errorf("Reserve(%q) error = %v, wantErr %v", tt.subdomain, err, tt.wantErr)
+			}
+		})
+	}
+	if by, _ := st.reservedBy("app"); by != "ci" {
+		t.Errorf("reservedBy = %q, want ci", by)
+	}
+	if err := st.Unreserve("app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Unreserve("app"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unreserve twice: error = %v, want ErrNotFound", err)
+	}
+
+	if err := st.AddDomain("WWW.Example.org", "app", "ci"); err != nil {
+		t.Fatal(err)
+	}
+	d, err := st.domainFor("www.example.org")
+	if err != nil || d == nil || d.Subdomain != "app" || d.Token != "ci" {
+		t.Errorf("domainFor = %+v, %v", d, err)
+	}
+	if err := st.RemoveDomain("www.EXAMPLE.org"); err != nil {
+		t.Errorf("RemoveDomain is case-sensitive: %v", err)
+	}
+}
+
+func TestStoreUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dr1ll.db")
+	st := openTestStore(t, path)
+
+	usage := map[string]*Usage{
+		"ci":      {Day: "2024-03-15", BytesToday: 10, Month: "2024-03", RequestsThisMonth: 2, BytesTotal: 100, RequestsTotal: 20},
+		"default": {Day: "2024-03-15", BytesTotal: 5},
+	}
+	if err := st.SaveUsage(usage); err != nil {
+		t.Fatal(err)
+	}
+	usage["ci"].RequestsTotal = 21
+	if err := st.SaveUsage(usage); err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+
+	loaded, err := openTestStore(t, path).LoadUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(usage) {
+		t.Fatalf("loaded %d entries, want %d", len(loaded), len(usage))
+	}
+	for token, want := range usage {
+		if got := loaded[token]; got == nil || *got != *want {
+			t.Errorf("usage[%s] = %+v, want %+v", token, got, want)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	s := NewServer("token", "example.com", "0")
+	st := openTestStore(t, filepath.Join(t.TempDir(), "dr1ll.db"))
+	if err := s.SetStore(st); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.AddDomain("www.example.org", "app", "ci"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host      string
+		subdomain string
+		custom    bool
+	}{
+		{host: "app.example.com", subdomain: "app"},
+		{host: "APP.example.com:8080", subdomain: "app"},
+		{host: "www.example.org", subdomain: "app", custom: true},
+		{host: "www.example.org:443", subdomain: "app", custom: true},
+		{host: "unknown.example.net", subdomain: "unknown"},
+	}
+	for _, tt := range tests {
+		subdomain, domain, err := s.route(tt.host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subdomain != tt.subdomain || (domain != nil) != tt.custom {
+			t.Errorf("route(%q) = (%q, %v), want (%q, custom %v)", tt.host, subdomain, domain, tt.subdomain, tt.custom)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/M1z23R/dr1ll/internal/server")
+
+// startRequestSpan opens the server span for a public request, continuing
+// the caller's trace when it sent a traceparent header.
+func startRequestSpan(r *http.Request, fwd forwardedInfo) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return tracer.Start(ctx, r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("url.scheme", fwd.proto),
+			attribute.String("server.address", r.Host),
+			attribute.String("client.address", fwd.clientIP),
+			attribute.String("user_agent.original", r.UserAgent()),
+		),
+	)
+}
+
+// endRequestSpan records the outcome of a public request and ends its span.
+func endRequestSpan(span trace.Span, tunnel, requestID string, status int) {
+	span.SetAttributes(
+		attribute.String("dr1ll.tunnel", tunnel),
+		attribute.Int("http.response.status_code", status),
+	)
+	if requestID != "" {
+		span.SetAttributes(attribute.String("dr1ll.request_id", requestID))
+	}
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// injectTrace carries the span context of ctx to the client in msg, so the
+// upstream call joins the same trace.
+func injectTrace(ctx context.Context, msg *Message) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	msg.TraceParent = carrier.Get("traceparent")
+	msg.TraceState = carrier.Get("tracestate")
+}
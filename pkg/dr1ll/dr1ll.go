@@ -0,0 +1,186 @@
+// Package dr1ll opens dr1ll tunnels from Go programs, for example so an
+// integration test can receive real webhooks without running the CLI.
+//
+//	tunnel, err := dr1ll.Dial(ctx, dr1ll.Options{
+//		ServerURL: "https://tunnel.example.com",
+//		Token:     os.Getenv("DR1LL_TOKEN"),
+//		Handler:   webhookHandler,
+//	})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer tunnel.Close()
+//	registerWebhook(tunnel.URL() + "/hooks")
+package dr1ll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/M1z23R/dr1ll/internal/client"
+)
+
+// Options configures a tunnel. ServerURL, Token and one of Handler or
+// Upstream are required.
+type Options struct {
+	ServerURL string // tunnel server, e.g. https://tunnel.example.com
+	Token     string // authentication token
+
+	// Handler serves tunneled requests in-process. It takes precedence
+	// over Upstream.
+	Handler http.Handler
+	// Upstream is a local service to forward requests to: a port ("3000"),
+	// a host and port ("10.0.0.5:8080") or an http(s) URL.
+	Upstream string
+
+	Subdomain      string        // requested subdomain; random if empty
+	BasicAuth      string        // "user:pass" required from callers
+	BearerToken    string        // bearer token required from callers
+	AllowIPs       []string      // CIDRs allowed to reach the tunnel
+	DenyIPs        []string      // CIDRs blocked from the tunnel
+	MaxConcurrency int           // max requests served at once; 0 means no limit
+	DrainTimeout   time.Duration // how long closing waits for in-flight requests; 0 means 30s
+
+	// Logger receives the tunnel's logs: connections, forwarded requests
+	// and errors. They are discarded when it is nil.
+	Logger *slog.Logger
+}
+
+// Tunnel is an open tunnel.
+type Tunnel struct {
+	url    string
+	client *client.Client
+	done   chan struct{}
+	err    error
+}
+
+// Dial opens a tunnel and waits until the server has assigned its public
+// URL. The tunnel stays open until ctx is done or Close is called; if ctx
+// is done before the tunnel is ready, Dial returns ctx.Err().
+func Dial(ctx context.Context, opts Options) (*Tunnel, error) {
+	if opts.ServerURL == "" || opts.Token == "" {
+		return nil, errors.New("dr1ll: ServerURL and Token are required")
+	}
+
+	c := client.NewClient(opts.ServerURL, opts.Token, 0)
+	c.SetQuiet(true)
+	if opts.Logger != nil {
+		c.SetLogger(opts.Logger)
+	} else {
+		c.SetLogger(slog.New(slog.DiscardHandler))
+	}
+	switch {
+	case opts.Handler != nil:
+		c.SetHandler(opts.Handler)
+	case opts.Upstream != "":
+		if err := c.SetUpstream(opts.Upstream); err != nil {
+			return nil, fmt.Errorf("dr1ll: %w", err)
+		}
+	default:
+		return nil, errors.New("dr1ll: Handler or Upstream is required")
+	}
+	if opts.Subdomain != "" {
+		c.SetRequestedSubdomain(opts.Subdomain)
+	}
+	if opts.BasicAuth != "" {
+		username, password, ok := strings.Cut(opts.BasicAuth, ":")
+		if !ok || username == "" {
+			return nil, errors.New("dr1ll: invalid BasicAuth, expected user:pass")
+		}
+		c.SetBasicAuth(username, password)
+	}
+	if opts.BearerToken != "" {
+		c.SetBearerToken(opts.BearerToken)
+	}
+	if len(opts.AllowIPs) > 0 || len(opts.DenyIPs) > 0 {
+		c.SetIPRules(opts.AllowIPs, opts.DenyIPs)
+	}
+	c.SetMaxConcurrency(opts.MaxConcurrency)
+	if opts.DrainTimeout > 0 {
+		c.SetDrainTimeout(opts.DrainTimeout)
+	}
+
+	ready := make(chan string, 1)
+	c.SetOnActive(func(host string) {
+		select {
+		case ready <- host:
+		default:
+		}
+	})
+
+	t := &Tunnel{client: c, done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		t.err = c.RunContext(ctx)
+	}()
+
+	select {
+	case host := <-ready:
+		t.url = publicURL(opts.ServerURL, host)
+		return t, nil
+	case <-t.done:
+		if t.err == nil {
+			t.err = errors.New("dr1ll: tunnel closed before it was ready")
+		}
+		return nil, t.err
+	case <-ctx.Done():
+		<-t.done
+		return nil, ctx.Err()
+	}
+}
+
+// URL returns the tunnel's public URL. Its scheme and port follow the
+// server URL, which fits servers reached directly or through a proxy on
+// the standard ports.
+func (t *Tunnel) URL() string {
+	return t.url
+}
+
+// Close stops the tunnel, waiting for in-flight requests to finish up to
+// the drain timeout. It returns the error the tunnel failed with, if any.
+func (t *Tunnel) Close() error {
+	t.client.Stop()
+	<-t.done
+	return t.err
+}
+
+// Done returns a channel closed once the tunnel has shut down, because
+// ctx was done, Close was called or the connection was lost.
+func (t *Tunnel) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns the error the tunnel failed with once Done is closed.
+func (t *Tunnel) Err() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+// publicURL builds the tunnel's URL from the host the server assigned,
+// taking the scheme and port from the server URL.
+func publicURL(serverURL, host string) string {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "https://" + host
+	}
+
+	scheme := "https"
+	if u.Scheme == "http" || u.Scheme == "ws" {
+		scheme = "http"
+	}
+	if port := u.Port(); port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	return scheme + "://" + host
+}
@@ -0,0 +1,219 @@
+package dr1ll_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/M1z23R/dr1ll/internal/server"
+	"github.com/M1z23R/dr1ll/pkg/dr1ll"
+)
+
+func TestMain(m *testing.M) {
+	// Keep the server's logs out of the test output.
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	os.Exit(m.Run())
+}
+
+// startServer runs a tunnel server for the domain localhost and returns
+// its URL and an HTTP client that reaches every tunnel host through it.
+func startServer(t *testing.T) (string, *http.Client) {
+	t.Helper()
+	s := server.NewServer("secret", "localhost", "0")
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+
+	addr := ts.Listener.Addr().String()
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		Timeout:       10 * time.Second,
+	}
+	return ts.URL, httpClient
+}
+
+func dial(t *testing.T, opts dr1ll.Options) *dr1ll.Tunnel {
+	t.Helper()
+	// The tunnel lives as long as the context passed to Dial.
+	tunnel, err := dr1ll.Dial(t.Context(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tunnel.Close() })
+	return tunnel
+}
+
+// echo reports what the app behind the tunnel received.
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("X-Method", r.Method)
+	w.Header().Set("X-URI", r.URL.RequestURI())
+	w.Header().Set("X-Got-Forwarded-For", r.Header.Get("X-Forwarded-For"))
+	w.Header().Set("X-Got-Hop", r.Header.Get("X-Hop"))
+	w.Header().Add("Set-Cookie", "a=1")
+	w.Header().Add("Set-Cookie", "b=2")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(body)
+})
+
+func TestTunnel(t *testing.T) {
+	serverURL, httpClient := startServer(t)
+	tunnel := dial(t, dr1ll.Options{ServerURL: serverURL, Token: "secret", Handler: echo, Subdomain: "e2e"})
+
+	if !strings.HasPrefix(tunnel.URL(), "http://e2e.localhost:") {
+		t.Fatalf("URL = %q", tunnel.URL())
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		header map[string]string
+		want   map[string]string
+	}{
+		{
+			name:   "query string",
+			method: "GET",
+			path:   "/search?q=a+b&page=2",
+			want:   map[string]string{"X-Method": "GET", "X-URI": "/search?q=a+b&page=2"},
+		},
+		{
+			name:   "body",
+			method: "POST",
+			path:   "/hooks",
+			body:   `{"event":"push"}`,
+			want:   map[string]string{"X-Method": "POST", "X-URI": "/hooks"},
+		},
+		{
+			name:   "forwarded for and hop-by-hop",
+			method: "GET",
+			path:   "/",
+			header: map[string]string{"Connection": "X-Hop", "X-Hop": "secret", "X-Forwarded-For": "203.0.113.9"},
+			want:   map[string]string{"X-Got-Forwarded-For": "127.0.0.1", "X-Got-Hop": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tunnel.URL()+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			resp, err := httpClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != http.StatusCreated {
+				t.Fatalf("status = %d: %s", resp.StatusCode, body)
+			}
+			if string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+			for name, value := range tt.want {
+				if got := resp.Header.Get(name); got != value {
+					t.Errorf("%s = %q, want %q", name, got, value)
+				}
+			}
+			if cookies := resp.Header.Values("Set-Cookie"); !slices.Equal(cookies, []string{"a=1", "b=2"}) {
+				t.Errorf("Set-Cookie = %q", cookies)
+			}
+		})
+	}
+}
+
+func TestTunnelAuth(t *testing.T) {
+	serverURL, httpClient := startServer(t)
+	tunnel := dial(t, dr1ll.Options{
+		ServerURL: serverURL, Token: "secret", Handler: echo, BasicAuth: "alice:s3cret", BearerToken: "tok",
+	})
+
+	tests := []struct {
+		name string
+		auth func(r *http.Request)
+		want int
+	}{
+		{name: "none", auth: func(*http.Request) {}, want: http.StatusUnauthorized},
+		{name: "basic", auth: func(r *http.Request) { r.SetBasicAuth("alice", "s3cret") }, want: http.StatusCreated},
+		{name: "wrong basic", auth: func(r *http.Request) { r.SetBasicAuth("alice", "nope") }, want: http.StatusUnauthorized},
+		{name: "bearer", auth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer tok") }, want: http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tunnel.URL()+"/", nil)
+			tt.auth(req)
+			resp, err := httpClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialErrors(t *testing.T) {
+	serverURL, _ := startServer(t)
+	tests := []struct {
+		name string
+		opts dr1ll.Options
+	}{
+		{name: "no server", opts: dr1ll.Options{Token: "secret", Handler: echo}},
+		{name: "no handler", opts: dr1ll.Options{ServerURL: serverURL, Token: "secret"}},
+		{name: "bad basic auth", opts: dr1ll.Options{ServerURL: serverURL, Token: "secret", Handler: echo, BasicAuth: "alice"}},
+		{name: "wrong token", opts: dr1ll.Options{ServerURL: serverURL, Token: "wrong", Handler: echo}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if tunnel, err := dr1ll.Dial(ctx, tt.opts); err == nil {
+				tunnel.Close()
+				t.Error("Dial succeeded")
+			}
+		})
+	}
+}
+
+func TestClose(t *testing.T) {
+	serverURL, httpClient := startServer(t)
+	tunnel := dial(t, dr1ll.Options{ServerURL: serverURL, Token: "secret", Handler: echo, DrainTimeout: time.Second})
+
+	if err := tunnel.Close(); err != nil {
+		t.Errorf("Close = %v", err)
+	}
+	select {
+	case <-tunnel.Done():
+	default:
+		t.Error("Done is not closed after Close")
+	}
+
+	resp, err := httpClient.Get(tunnel.URL() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		t.Error("closed tunnel still serves requests")
+	}
+}